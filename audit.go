@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog/v2"
+)
+
+const defaultAuditInterval = 10 * time.Minute
+
+// AuditStats informer 缓存与存储的一致性审计统计
+type AuditStats struct {
+	LastRun time.Time
+	Runs    int
+	Cached  int
+	Stored  int
+	// Missing 缓存中存在但存储中没有的对象数
+	Missing int
+	// Stale 存储中 ResourceVersion 与缓存不一致的对象数
+	Stale int
+	// Orphaned 存储中存在但缓存中已没有的记录数
+	Orphaned int
+	// Repaired 累计入队修复的 key 数和删除的孤立记录数
+	Repaired int
+}
+
+// auditor 保存控制器最近一次审计的统计
+type auditor struct {
+	mu    sync.RWMutex
+	stats AuditStats
+}
+
+func (a *auditor) get() AuditStats {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.stats
+}
+
+func (a *auditor) record(run AuditStats) AuditStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	run.Runs = a.stats.Runs + 1
+	run.Repaired += a.stats.Repaired
+	a.stats = run
+	return run
+}
+
+// runAuditor 按周期执行审计，直到 ctx 取消
+func (c *Controller) runAuditor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := c.Audit(ctx); err != nil {
+				klog.Errorf("Audit %s failed: %v", c.name, err)
+			}
+		}
+	}
+}
+
+// Audit 比较缓存和存储中每个对象的 UID 与 ResourceVersion，将不一致的 key 放回工作队列修复
+// ResourceVersion 不同但内容相同时（见 DefaultNeedUpdate）不视为过期
+func (c *Controller) Audit(ctx context.Context) (AuditStats, error) {
+	objs, err := c.list()
+	if err != nil {
+		return AuditStats{}, err
+	}
+
+	run := AuditStats{LastRun: time.Now(), Cached: len(objs)}
+	cached := make(map[string]*unstructured.Unstructured, len(objs))
+	for _, obj := range objs {
+		uObj := obj.(*unstructured.Unstructured)
+		cached[string(uObj.GetUID())] = uObj
+	}
+
+	// keys namespace/name -> 修复动作
+	keys := make(map[string]string)
+	removed := 0
+	for _, storage := range c.unit.GetStorage() {
+		models, err := storage.Find(ctx)
+		if err != nil {
+			return run, fmt.Errorf("audit %s: %w", c.name, err)
+		}
+		run.Stored += len(models)
+
+		stored := make(map[string]struct{}, len(models))
+		for _, model := range models {
+			uid := model.GetUID()
+			stored[uid] = struct{}{}
+			obj, ok := cached[uid]
+			if !ok {
+				run.Orphaned++
+				// 同名对象以新 UID 重建时新对象插入新行，旧行不会被修复，按 UID 删除，不能按名称删除
+				if c.cachedUID(model.GetNamespace(), model.GetName()) == uid {
+					// list 之后创建的对象
+					continue
+				}
+				if err = storage.DeleteByUID(ctx, uid, DeleteInfo{Reason: DeleteReasonAudit}); err != nil {
+					return run, fmt.Errorf("audit %s: delete %s: %w", c.name, uid, err)
+				}
+				removed++
+				continue
+			}
+			if model.GetResourceVersion() != obj.GetResourceVersion() && !storedEqual(model, obj) {
+				run.Stale++
+				keys[objectKey(obj.GetNamespace(), obj.GetName())] = ActionUpdate
+			}
+		}
+		for uid, obj := range cached {
+			if _, ok := stored[uid]; !ok {
+				run.Missing++
+				keys[objectKey(obj.GetNamespace(), obj.GetName())] = ActionAdd
+			}
+		}
+	}
+
+	for key, action := range keys {
+		namespace, name, _ := parseKey(key)
+		c.enqueue(action, namespace, name)
+	}
+	run.Repaired = len(keys) + removed
+	stats := c.auditor.record(run)
+
+	resource := c.gvr.String()
+	auditRuns.WithLabelValues(c.clusterID, resource).Inc()
+	auditRepairs.WithLabelValues(c.clusterID, resource).Add(float64(run.Repaired))
+	auditDrift.WithLabelValues(c.clusterID, resource, "missing").Set(float64(run.Missing))
+	auditDrift.WithLabelValues(c.clusterID, resource, "stale").Set(float64(run.Stale))
+	auditDrift.WithLabelValues(c.clusterID, resource, "orphaned").Set(float64(run.Orphaned))
+
+	if run.Repaired > 0 {
+		klog.Warningf("Audit %s: %d missing, %d stale, %d orphaned, %d keys enqueued, %d orphaned rows removed",
+			c.name, run.Missing, run.Stale, run.Orphaned, len(keys), removed)
+	} else {
+		klog.V(2).Infof("Audit %s: cache and storage are consistent", c.name)
+	}
+	return stats, nil
+}
+
+// cachedUID 返回缓存中 namespace/name 当前的 UID，不存在时为空
+func (c *Controller) cachedUID(namespace, name string) string {
+	obj, err := c.get(namespace, name)
+	if err != nil {
+		return ""
+	}
+	return string(obj.(*unstructured.Unstructured).GetUID())
+}
+
+// storedEqual 存储的对象与缓存中的对象除 ResourceVersion 外是否相同，无法解析时视为不同
+func storedEqual(model BaseModel, obj *unstructured.Unstructured) bool {
+	stored, err := model.ToUnstructured()
+	if err != nil {
+		return false
+	}
+	return equalIgnoringResourceVersion(stored, obj)
+}
+
+// AuditStats 返回最近一次审计的统计
+func (c *Controller) AuditStats() AuditStats {
+	return c.auditor.get()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestAudit(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	d := newTestDao(t, db)
+	c, indexer := newTestController(t, d)
+
+	moved := newTestPod("stale", "uid-stale", "2")
+	_ = unstructured.SetNestedField(moved.Object, "node-2", "spec", "nodeName")
+	for _, pod := range []*unstructured.Unstructured{
+		// 只有 resourceVersion 不同
+		newTestPod("same", "uid-same", "1"),
+		newTestPod("stale", "uid-stale", "1"),
+		newTestPod("orphan", "uid-orphan", "1"),
+		// 同名对象被删除后以新 UID 重建
+		newTestPod("recreated", "uid-old", "1"),
+	} {
+		if _, err := d.Upsert(ctx, pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, pod := range []*unstructured.Unstructured{
+		newTestPod("same", "uid-same", "2"),
+		moved,
+		newTestPod("recreated", "uid-new", "3"),
+		newTestPod("missing", "uid-missing", "1"),
+	} {
+		if err := indexer.Add(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := c.Audit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Missing != 2 || stats.Stale != 1 || stats.Orphaned != 2 || stats.Repaired != 5 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	for _, uid := range []string{"uid-orphan", "uid-old"} {
+		if reason := deletedReason(t, db, d, uid); reason != DeleteReasonAudit {
+			t.Errorf("%s: expected tombstone with reason %s, got %q", uid, DeleteReasonAudit, reason)
+		}
+	}
+	if reason := deletedReason(t, db, d, "uid-same"); reason != "" {
+		t.Errorf("unchanged row was tombstoned: %q", reason)
+	}
+
+	// 修复入队的对象写入后再次审计没有差异
+	if c.queue.Len() != 3 {
+		t.Fatalf("expected 3 keys enqueued, got %d", c.queue.Len())
+	}
+	for c.queue.Len() > 0 {
+		c.processNextItem()
+	}
+	stored, err := d.First(ctx, "default", "recreated")
+	if err != nil || stored.GetUID() != "uid-new" {
+		t.Fatalf("recreated object not stored: %v %v", stored, err)
+	}
+	stats, err = c.Audit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Missing != 0 || stats.Stale != 0 || stats.Orphaned != 0 || c.queue.Len() != 0 {
+		t.Fatalf("expected storage to be consistent after repair, got %+v", stats)
+	}
+}
@@ -2,9 +2,7 @@ package main
 
 import (
 	"context"
-	"errors"
 
-	"gorm.io/gorm"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
@@ -42,8 +40,13 @@ func NewBase(clusterID string, gvr schema.GroupVersionResource, namespaced bool,
 
 var DefaultNeedUpdateFN = DefaultNeedUpdate
 
+// DefaultNeedUpdate 比较裁剪后的新旧对象，忽略 metadata.resourceVersion，
+// 只有被裁剪的字段（如 managedFields、心跳时间）变化时不更新
 func DefaultNeedUpdate(old *unstructured.Unstructured, new *unstructured.Unstructured) bool {
-	return old.GetResourceVersion() != new.GetResourceVersion()
+	if old.GetResourceVersion() == new.GetResourceVersion() {
+		return false
+	}
+	return !equalIgnoringResourceVersion(old, new)
 }
 
 // WithNeedUpdate  选项函数：设置更新检查函数
@@ -97,8 +100,8 @@ func (b *Base) OnUpdate(ctx context.Context, ctrl *Controller, obj *unstructured
 	return b.onUpdate(ctx, ctrl, b.storage, obj)
 }
 
-func (b *Base) OnDelete(ctx context.Context, storage []Dao, namespace, name string) error {
-	return b.onDelete(ctx, storage, namespace, name)
+func (b *Base) OnDelete(ctx context.Context, storage []Dao, namespace, name string, info DeleteInfo) error {
+	return b.onDelete(ctx, storage, namespace, name, info)
 }
 
 func (b *Base) GetNamespaced() bool {
@@ -107,9 +110,9 @@ func (b *Base) GetNamespaced() bool {
 
 var DefaultDeleteFN = DefaultDelete
 
-func DefaultDelete(ctx context.Context, storages []Dao, namespace, name string) error {
+func DefaultDelete(ctx context.Context, storages []Dao, namespace, name string, info DeleteInfo) error {
 	for _, storage := range storages {
-		err := storage.Delete(ctx, namespace, name)
+		err := storage.Delete(ctx, namespace, name, info)
 		if err != nil {
 			return err
 		}
@@ -119,25 +122,11 @@ func DefaultDelete(ctx context.Context, storages []Dao, namespace, name string)
 
 var DefaultAddFN = DefaultAdd
 
+// DefaultAdd 使用 Upsert 写入，一次往返完成插入或更新，旧事件不会覆盖新数据
 func DefaultAdd(ctx context.Context, ctrl *Controller, storages []Dao, obj *unstructured.Unstructured) error {
 	for _, storage := range storages {
-		// 查询是否存在
-		model, err := storage.First(ctx, obj.GetNamespace(), obj.GetName())
-		if err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				// 不存在则创建
-				err = storage.Create(ctx, obj)
-				if err != nil {
-				}
-				continue
-			}
-			continue
-		}
-		if storage.NeedUpdate(ctx, obj, model) {
-			err = storage.Save(ctx, obj)
-			if err != nil {
-				return err
-			}
+		if _, err := storage.Upsert(ctx, obj); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -149,6 +138,6 @@ type Unit interface {
 	GetStorage() []Dao
 	OnAdd(ctx context.Context, ctrl *Controller, obj *unstructured.Unstructured) error
 	OnUpdate(ctx context.Context, ctrl *Controller, obj *unstructured.Unstructured) error
-	OnDelete(ctx context.Context, storage []Dao, namespace, name string) error
+	OnDelete(ctx context.Context, storage []Dao, namespace, name string, info DeleteInfo) error
 	GetNamespaced() bool
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog/v2"
+)
+
+// defaultBatchInterval 批量写入的默认刷新间隔
+const defaultBatchInterval = time.Second
+
+// txKey context 中保存事务的键
+type txKey struct{}
+
+// withTx 返回携带事务的 context，Dao 在该 context 下的读写都使用事务
+func withTx(ctx context.Context, tx *gorm.DB) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// conn 返回 ctx 中的事务，没有事务时返回 db
+func conn(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return db.WithContext(ctx)
+}
+
+// transaction 在事务中执行 fn，ctx 已携带事务时直接复用，由外层事务提交
+func transaction(ctx context.Context, db *gorm.DB, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return fn(ctx)
+	}
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(withTx(ctx, tx))
+	})
+}
+
+// writeOp 一次待写入的变更，由队列键解析得到
+type writeOp struct {
+	// key 变更对应的队列键，写入提交后确认
+	key       string
+	action    string
+	namespace string
+	name      string
+	obj       *unstructured.Unstructured
+	info      DeleteInfo
+	// replaced 同名对象被删除后重建时旧对象的最终状态，写入新对象前先删除
+	replaced *DeleteInfo
+	// generation 从缓存读取对象时分配的写入代数
+	generation int64
+}
+
+// writeBatcher 控制器的写缓冲，按数量或间隔在一个事务中批量写入
+// 队列键在事务提交后才调用 Done，同一对象在一个批次中最多有一个变更，处理期间重新入队的键会在提交后再次处理
+type writeBatcher struct {
+	ctrl     *Controller
+	db       *gorm.DB
+	size     int
+	interval time.Duration
+
+	mu sync.Mutex
+	// pending 按到达顺序排列的变更
+	pending []*writeOp
+	flush   chan struct{}
+	// flushMu 保证同一时间只有一个批次在写入，同一对象的变更按顺序提交
+	flushMu sync.Mutex
+}
+
+func newWriteBatcher(ctrl *Controller, db *gorm.DB, size int, interval time.Duration) *writeBatcher {
+	return &writeBatcher{
+		ctrl:     ctrl,
+		db:       db,
+		size:     size,
+		interval: interval,
+		flush:    make(chan struct{}, 1),
+	}
+}
+
+// Add 加入一个变更，同一对象的连续事件已在队列中合并为一个键，键在提交前不会再次取出，这里无需合并
+func (b *writeBatcher) Add(op *writeOp) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = append(b.pending, op)
+	if len(b.pending) >= b.size {
+		select {
+		case b.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run 按间隔或数量刷新，ctx 取消后返回，剩余的变更由控制器在 worker 退出后写入
+func (b *writeBatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-b.flush:
+		}
+		b.Flush(ctx)
+	}
+}
+
+// Flush 在一个事务中写入所有待写入的变更，失败时逐个重试，只让失败的变更重新入队
+func (b *writeBatcher) Flush(ctx context.Context) {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	ops := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+	if len(ops) == 0 {
+		return
+	}
+
+	batchSize.WithLabelValues(b.ctrl.clusterID, b.ctrl.name).Observe(float64(len(ops)))
+	err := b.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		txCtx := withTx(ctx, tx)
+		for _, op := range ops {
+			if err := b.ctrl.apply(txCtx, op); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		for _, op := range ops {
+			b.ctrl.finish(op, nil)
+		}
+		return
+	}
+
+	klog.Warningf("Batch write of %d objects for %s failed, retrying one by one: %v", len(ops), b.ctrl.name, err)
+	batchFailures.WithLabelValues(b.ctrl.clusterID, b.ctrl.name).Inc()
+	for _, op := range ops {
+		b.ctrl.finish(op, b.ctrl.apply(ctx, op))
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// waitStored 等待对象写入存储
+func waitStored(t *testing.T, d Dao, names ...string) {
+	t.Helper()
+	ctx := context.Background()
+	err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+		for _, name := range names {
+			if _, err := d.First(ctx, "default", name); err != nil {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("%v not written", names)
+	}
+}
+
+func TestWriteBatcherFlushedOnStop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := newTestDB(t)
+	cm := newTestManager()
+	cm.UseDB(db)
+	cm.dbOptions = DatabaseConfig{BatchSize: 100, BatchInterval: &metav1.Duration{Duration: time.Hour}}
+	cm.createControllerForGVR(CoreV1Pod, true)
+	c := cm.GetController(CoreV1Pod)
+	cm.startControllers(ctx)
+
+	if _, err := cm.dynamicClient.Resource(CoreV1Pod).Namespace("default").
+		Create(ctx, newTestPod("web", "uid-1", "1"), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		c.batcher.mu.Lock()
+		defer c.batcher.mu.Unlock()
+		return len(c.batcher.pending) > 0, nil
+	})
+	if err != nil {
+		t.Fatal("object not handed to the batcher")
+	}
+
+	// 停止时等待控制器写入批次中剩余的变更
+	cancel()
+	cm.waitControllers()
+	if _, err = c.unit.GetStorage()[0].First(context.Background(), "default", "web"); err != nil {
+		t.Fatalf("pending write lost on stop: %v", err)
+	}
+}
+
+func TestWriteBatcherAcksAfterCommit(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	d := newTestDao(t, db)
+	c, indexer := newTestController(t, d)
+	c.batcher = newWriteBatcher(c, db, 10, time.Hour)
+
+	pod := newTestPod("web", "uid-1", "1")
+	if err := indexer.Add(pod); err != nil {
+		t.Fatal(err)
+	}
+	c.onAdd(pod)
+	if !c.processNextItem() {
+		t.Fatal("queue shut down")
+	}
+	if _, err := d.First(ctx, "default", "web"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expected nothing written before flush, got %v", err)
+	}
+	// 提交前键仍在处理中，新的事件不会被取出
+	c.enqueue(ActionUpdate, "default", "web")
+	if c.queue.Len() != 0 {
+		t.Fatal("key acknowledged before the batch was committed")
+	}
+
+	c.batcher.Flush(ctx)
+	if _, err := d.First(ctx, "default", "web"); err != nil {
+		t.Fatalf("expected the object to be written: %v", err)
+	}
+	if c.queue.Len() != 1 {
+		t.Fatal("key not acknowledged after commit")
+	}
+}
+
+func TestWriteBatcherFlushBySize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := newTestDB(t)
+	d := newTestDao(t, db)
+	c, indexer := newTestController(t, d)
+	c.batcher = newWriteBatcher(c, db, 2, time.Hour)
+	go c.batcher.Run(ctx)
+
+	for i, name := range []string{"web", "api"} {
+		pod := newTestPod(name, "uid-"+name, "1")
+		if err := indexer.Add(pod); err != nil {
+			t.Fatal(err)
+		}
+		c.onAdd(pod)
+		if !c.processNextItem() {
+			t.Fatal("queue shut down")
+		}
+		if i == 0 {
+			if _, err := d.First(ctx, "default", name); !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Fatalf("flushed before the batch was full: %v", err)
+			}
+		}
+	}
+	waitStored(t, d, "web", "api")
+}
+
+func TestWriteBatcherFlushByInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := newTestDB(t)
+	d := newTestDao(t, db)
+	c, indexer := newTestController(t, d)
+	c.batcher = newWriteBatcher(c, db, 100, 20*time.Millisecond)
+	go c.batcher.Run(ctx)
+
+	pod := newTestPod("web", "uid-1", "1")
+	if err := indexer.Add(pod); err != nil {
+		t.Fatal(err)
+	}
+	c.onAdd(pod)
+	if !c.processNextItem() {
+		t.Fatal("queue shut down")
+	}
+	waitStored(t, d, "web")
+}
+
+func TestWriteBatcherRetriesFailedOps(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	d := newTestDao(t, db)
+	c, _ := newTestController(t, d)
+	b := newWriteBatcher(c, db, 10, time.Hour)
+
+	c.queue.Add("default/web")
+	c.queue.Add("default/bad")
+	good, _ := c.queue.Get()
+	bad, _ := c.queue.Get()
+	b.Add(&writeOp{key: good, action: ActionAdd, namespace: "default", name: "web", obj: newTestPod("web", "uid-1", "1")})
+	b.Add(&writeOp{key: bad, action: "bogus", namespace: "default", name: "bad"})
+	b.Flush(ctx)
+
+	// 事务回滚后逐个重试，只有失败的变更重新入队
+	if _, err := d.First(ctx, "default", "web"); err != nil {
+		t.Fatalf("expected the valid op to be written on retry: %v", err)
+	}
+	if c.queue.NumRequeues(good) != 0 || c.queue.NumRequeues(bad) != 1 {
+		t.Fatalf("unexpected requeues: good=%d bad=%d", c.queue.NumRequeues(good), c.queue.NumRequeues(bad))
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// command 子命令定义
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, cfg *Config, args []string) error
+}
+
+var commands = []command{
+	{name: "run", usage: "start controllers and keep syncing (default)", run: runCommand},
+	{name: "migrate", usage: "run AutoMigrate for every whitelisted resource and exit", run: migrateCommand},
+	{name: "resync", usage: "list every whitelisted resource once, reconcile the database with it and exit", run: resyncCommand},
+	{name: "export", usage: "dump stored rows as a multi-document YAML stream", run: exportCommand},
+	{name: "snapshot", usage: "print objects of a resource as they existed at a point in time (needs history)", run: snapshotCommand},
+	{name: "check", usage: "validate config and check API server and database connectivity", run: checkCommand},
+	{name: "manifest", usage: "print a Deployment and minimal RBAC for the configured whitelist", run: manifestCommand},
+}
+
+// findCommand 根据名称查找子命令
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] <command> [command flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-8s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+// newManager 根据配置创建单个集群的 ControllerManager
+func newManager(cfg *Config, cluster ClusterConfig) (*ControllerManager, error) {
+	manager := NewControllerManager(cluster.ID, nil)
+	if err := manager.ApplyConfig(cfg); err != nil {
+		return nil, err
+	}
+	manager.ApplyClusterConfig(cluster)
+	return manager, nil
+}
+
+// selectClusters 返回 -cluster 指定的集群，未指定时返回所有集群
+func selectClusters(cfg *Config, id string) ([]ClusterConfig, error) {
+	clusters := cfg.ClusterConfigs()
+	if id == "" {
+		return clusters, nil
+	}
+	for _, cluster := range clusters {
+		if cluster.ID == id {
+			return []ClusterConfig{cluster}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown cluster %q", id)
+}
+
+// selectCluster 返回 -cluster 指定的集群，只配置了一个集群时可以省略
+// hub 模式下集群不在配置文件中，只用于读取数据库，接受任意集群 ID
+func selectCluster(cfg *Config, id string) (ClusterConfig, error) {
+	clusters, err := selectClusters(cfg, id)
+	if err != nil {
+		if cfg.Hub != nil {
+			return ClusterConfig{ID: id}, nil
+		}
+		return ClusterConfig{}, err
+	}
+	if len(clusters) != 1 {
+		return ClusterConfig{}, fmt.Errorf("-cluster is required when multiple clusters are configured")
+	}
+	return clusters[0], nil
+}
+
+// runCommand 为每个集群启动一个 ControllerManager，收到 SIGHUP 时重新加载配置，增删集群无需重启进程
+func runCommand(ctx context.Context, cfg *Config, args []string) error {
+	if err := flag.NewFlagSet("run", flag.ExitOnError).Parse(args); err != nil {
+		return err
+	}
+	db, err := OpenDatabase(ctx, cfg.DSN, cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = CloseDatabase(db) }()
+
+	if cfg.MetricsAddr != "" {
+		go func() {
+			if err := ServeMetrics(ctx, cfg.MetricsAddr); err != nil {
+				klog.Errorf("Serve metrics failed: %v", err)
+			}
+		}()
+	}
+
+	registry := NewClusterRegistry(cfg, db)
+	defer registry.Shutdown()
+	registry.Sync(ctx, cfg)
+
+	if cfg.Hub != nil {
+		watcher, err := NewHubWatcher(*cfg.Hub, registry)
+		if err != nil {
+			return err
+		}
+		// 等待 watcher 退出后再 Shutdown，避免退出过程中注册新集群
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			watcher.Run(ctx)
+		}()
+		defer func() { <-done }()
+	}
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-reload:
+			newCfg, err := LoadConfig(configPath)
+			if err != nil {
+				klog.Errorf("Reload config failed: %v", err)
+				continue
+			}
+			if newCfg.DSN != cfg.DSN || !reflect.DeepEqual(newCfg.Database, cfg.Database) || newCfg.MetricsAddr != cfg.MetricsAddr ||
+				!reflect.DeepEqual(newCfg.Hub, cfg.Hub) {
+				klog.Warning("Database, metrics and hub settings only take effect after restart")
+			}
+			registry.Sync(ctx, newCfg)
+			klog.Infof("Config reloaded, clusters: %s", strings.Join(registry.Clusters(), ", "))
+		}
+	}
+}
+
+func migrateCommand(ctx context.Context, cfg *Config, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	clusterID := fs.String("cluster", "", "only use this cluster (default all)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	clusters, err := selectClusters(cfg, *clusterID)
+	if err != nil {
+		return err
+	}
+	// 表结构与集群无关，但不同集群可能安装了不同的 CRD
+	for _, cluster := range clusters {
+		if err = forCluster(ctx, cfg, cluster, func(manager *ControllerManager) error {
+			return manager.Migrate(ctx)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func resyncCommand(ctx context.Context, cfg *Config, args []string) error {
+	fs := flag.NewFlagSet("resync", flag.ExitOnError)
+	clusterID := fs.String("cluster", "", "only resync this cluster (default all)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	clusters, err := selectClusters(cfg, *clusterID)
+	if err != nil {
+		return err
+	}
+	for _, cluster := range clusters {
+		if err = forCluster(ctx, cfg, cluster, func(manager *ControllerManager) error {
+			if err := manager.Migrate(ctx); err != nil {
+				return err
+			}
+			return manager.Resync(ctx)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forCluster 初始化集群的 ControllerManager 后执行 fn
+func forCluster(ctx context.Context, cfg *Config, cluster ClusterConfig, fn func(*ControllerManager) error) error {
+	manager, err := newManager(cfg, cluster)
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+	if err = manager.Init(ctx); err != nil {
+		return fmt.Errorf("cluster %s: %w", cluster.ID, err)
+	}
+	if err = fn(manager); err != nil {
+		return fmt.Errorf("cluster %s: %w", cluster.ID, err)
+	}
+	return nil
+}
+
+func exportCommand(ctx context.Context, cfg *Config, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	output := fs.String("o", "-", "output file, - for stdout")
+	resource := fs.String("resource", "", "only export this resource, e.g. apps/deployments; the version is discovered from the API server")
+	namespace := fs.String("namespace", "", "only export objects in this namespace")
+	clusterID := fs.String("cluster", "", "export rows of this cluster, required when multiple clusters are configured")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cluster, err := selectCluster(cfg, *clusterID)
+	if err != nil {
+		return err
+	}
+	manager, err := newManager(cfg, cluster)
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+	if err = manager.OpenDB(ctx); err != nil {
+		return err
+	}
+	gvrs := manager.Whitelist()
+	if *resource != "" {
+		gr, err := ParseGroupResource(*resource)
+		if err != nil {
+			return err
+		}
+		gvr, err := manager.resolveGroupResource(gr)
+		if err != nil {
+			return err
+		}
+		gvrs = []schema.GroupVersionResource{gvr}
+	} else if manager.hasWhitelistPatterns() {
+		klog.Warning("Whitelist patterns and categories are not expanded without the API server, use -resource to export matched resources")
+	}
+
+	w, closeFn, err := openOutput(*output)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	for _, gvr := range gvrs {
+		// 未指定 -resource 时只读取数据库，不需要连接 API Server，namespaced 仅影响按名称查询
+		models, err := manager.GetDao(gvr, false).Find(ctx)
+		if err != nil {
+			return fmt.Errorf("export %s: %w", gvr, err)
+		}
+		count := 0
+		for _, model := range models {
+			obj, err := model.ToUnstructured()
+			if err != nil {
+				return fmt.Errorf("export %s: %w", gvr, err)
+			}
+			if *namespace != "" && obj.GetNamespace() != *namespace {
+				continue
+			}
+			if err = writeYAMLDocument(w, obj.Object); err != nil {
+				return err
+			}
+			count++
+		}
+		klog.Infof("Exported %d objects for %s", count, gvr)
+	}
+	return nil
+}
+
+func snapshotCommand(ctx context.Context, cfg *Config, args []string) error {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	output := fs.String("o", "-", "output file, - for stdout")
+	resource := fs.String("resource", "", "resource to reconstruct, e.g. apps/deployments (required); the version is discovered from the API server")
+	namespace := fs.String("namespace", "", "only reconstruct objects in this namespace")
+	at := fs.String("at", "", "point in time in RFC3339 format, e.g. 2026-10-13T15:04:05Z (default now)")
+	clean := fs.Bool("clean", false, "strip status and server-populated metadata so the output can be re-applied")
+	clusterID := fs.String("cluster", "", "reconstruct objects of this cluster, required when multiple clusters are configured")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *resource == "" {
+		return fmt.Errorf("-resource is required")
+	}
+	gr, err := ParseGroupResource(*resource)
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now()
+	if *at != "" {
+		if timestamp, err = time.Parse(time.RFC3339, *at); err != nil {
+			return fmt.Errorf("invalid -at: %w", err)
+		}
+	}
+
+	cluster, err := selectCluster(cfg, *clusterID)
+	if err != nil {
+		return err
+	}
+	manager, err := newManager(cfg, cluster)
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+	gvr, err := manager.resolveGroupResource(gr)
+	if err != nil {
+		return err
+	}
+	if err = manager.OpenDB(ctx); err != nil {
+		return err
+	}
+	objs, err := manager.Snapshot(ctx, gvr, *namespace, timestamp)
+	if err != nil {
+		return err
+	}
+
+	w, closeFn, err := openOutput(*output)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+	for _, obj := range objs {
+		if *clean {
+			cleanObject(obj)
+		}
+		if err = writeYAMLDocument(w, obj.Object); err != nil {
+			return err
+		}
+	}
+	klog.Infof("Reconstructed %d objects for %s at %s", len(objs), gvr, timestamp.Format(time.RFC3339))
+	return nil
+}
+
+// cleanObject 去掉状态和服务端填充的元数据
+func cleanObject(obj *unstructured.Unstructured) {
+	unstructured.RemoveNestedField(obj.Object, "status")
+	for _, field := range []string{"uid", "resourceVersion", "generation", "creationTimestamp", "managedFields", "ownerReferences", "selfLink"} {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+}
+
+// openOutput 打开输出文件，- 表示标准输出
+func openOutput(path string) (io.Writer, func(), error) {
+	if path == "-" {
+		return os.Stdout, func() {}, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, func() { _ = f.Close() }, nil
+}
+
+func checkCommand(ctx context.Context, cfg *Config, args []string) error {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	clusterID := fs.String("cluster", "", "only check this cluster (default all)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	// 配置在加载时已完成校验
+	klog.Info("Config is valid")
+	clusters, err := selectClusters(cfg, *clusterID)
+	if err != nil {
+		return err
+	}
+	for _, cluster := range clusters {
+		manager, err := newManager(cfg, cluster)
+		if err != nil {
+			return err
+		}
+		err = manager.Check(ctx)
+		_ = manager.Close()
+		if err != nil {
+			return fmt.Errorf("cluster %s: %w", cluster.ID, err)
+		}
+	}
+	return nil
+}
+
+func manifestCommand(ctx context.Context, cfg *Config, args []string) error {
+	fs := flag.NewFlagSet("manifest", flag.ExitOnError)
+	namespace := fs.String("namespace", "kubesync", "namespace to deploy kubesync into")
+	image := fs.String("image", "kubesync:latest", "container image")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	objects, err := GenerateManifests(cfg, ManifestOptions{Namespace: *namespace, Image: *image})
+	if err != nil {
+		return err
+	}
+	return WriteManifests(os.Stdout, objects)
+}
+
+// writeYAMLDocument 以 --- 分隔写入一个 YAML 文档
+func writeYAMLDocument(w io.Writer, obj any) error {
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(w, "---\n"); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
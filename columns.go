@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	gormschema "gorm.io/gorm/schema"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/jsonpath"
+	"k8s.io/klog/v2"
+)
+
+// 自定义列的类型
+const (
+	ColumnTypeString = "string"
+	ColumnTypeInt    = "int"
+	ColumnTypeFloat  = "float"
+	ColumnTypeBool   = "bool"
+	ColumnTypeTime   = "time"
+	// ColumnTypeJSON 所有匹配结果的 JSON 数组
+	ColumnTypeJSON = "json"
+)
+
+// columnNamePattern 自定义列名，直接作为数据库列名使用
+var columnNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// columnSpecPattern 列定义，如 .spec.replicas (int)，类型省略时为 string
+var columnSpecPattern = regexp.MustCompile(`^(.+?)(?:\s+\((\w+)\))?$`)
+
+// columnExtractor 从对象中提取一个自定义列
+type columnExtractor struct {
+	name       string
+	columnType string
+	// path 执行时会修改内部状态，并发使用需要持有 mu
+	path *jsonpath.JSONPath
+	mu   *sync.Mutex
+}
+
+// parseColumns 解析 GVR 配置中的自定义列，按列名排序，列名不能与资源模型的内置列重复（忽略大小写）
+// 表达式为 kubectl 风格的 JSONPath，可省略外层的 {}，如 .spec.containers[*].image
+func parseColumns(gr schema.GroupResource, columns map[string]string) ([]columnExtractor, error) {
+	if len(columns) == 0 {
+		return nil, nil
+	}
+	builtin, err := builtinColumns(gr)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(columns))
+	for name := range columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	extractors := make([]columnExtractor, 0, len(columns))
+	for _, name := range names {
+		if !columnNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid column name %q", name)
+		}
+		for _, column := range builtin {
+			if strings.EqualFold(name, column) {
+				return nil, fmt.Errorf("column %s conflicts with built-in column %s", name, column)
+			}
+		}
+		m := columnSpecPattern.FindStringSubmatch(strings.TrimSpace(columns[name]))
+		if m == nil {
+			return nil, fmt.Errorf("column %s: empty expression", name)
+		}
+		expr, columnType := m[1], m[2]
+		switch columnType {
+		case "":
+			columnType = ColumnTypeString
+		case ColumnTypeString, ColumnTypeInt, ColumnTypeFloat, ColumnTypeBool, ColumnTypeTime, ColumnTypeJSON:
+		default:
+			return nil, fmt.Errorf("column %s: unknown type %q", name, columnType)
+		}
+		if !strings.HasPrefix(expr, "{") {
+			expr = "{" + expr + "}"
+		}
+		path := jsonpath.New(name).AllowMissingKeys(true)
+		if err := path.Parse(expr); err != nil {
+			return nil, fmt.Errorf("column %s: %w", name, err)
+		}
+		extractors = append(extractors, columnExtractor{name: name, columnType: columnType, path: path, mu: &sync.Mutex{}})
+	}
+	return extractors, nil
+}
+
+// builtinColumns 资源模型的列名，未注册类型化模型时为 DynamicModel 的列
+func builtinColumns(gr schema.GroupResource) ([]string, error) {
+	var model BaseModel = &DynamicModel{Group: gr.Group, Resource: gr.Resource}
+	if fn := lookupModel(gr.WithVersion("")); fn != nil {
+		model = fn(context.Background(), model.(*DynamicModel), nil)
+	}
+	s, err := gormschema.Parse(model, &sync.Map{}, gormschema.NamingStrategy{})
+	if err != nil {
+		return nil, err
+	}
+	return s.DBNames, nil
+}
+
+// goType 列对应的 Go 类型，用于建表
+func (c columnExtractor) goType() reflect.Type {
+	switch c.columnType {
+	case ColumnTypeInt:
+		return reflect.TypeOf(int64(0))
+	case ColumnTypeFloat:
+		return reflect.TypeOf(float64(0))
+	case ColumnTypeBool:
+		return reflect.TypeOf(false)
+	case ColumnTypeTime:
+		return reflect.TypeOf(&time.Time{})
+	default:
+		return reflect.TypeOf("")
+	}
+}
+
+// tag 列的 gorm 标签，字符串和 JSON 使用 text，长度不受限制
+func (c columnExtractor) tag() reflect.StructTag {
+	if c.columnType == ColumnTypeString || c.columnType == ColumnTypeJSON {
+		return reflect.StructTag(fmt.Sprintf(`gorm:"column:%s;type:text"`, c.name))
+	}
+	return reflect.StructTag(fmt.Sprintf(`gorm:"column:%s"`, c.name))
+}
+
+// extract 提取列值，路径不存在时返回 nil，写入 NULL
+func (c columnExtractor) extract(obj *unstructured.Unstructured) (any, error) {
+	c.mu.Lock()
+	results, err := c.path.FindResults(obj.Object)
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	var values []any
+	for _, result := range results {
+		for _, v := range result {
+			if v.IsValid() && v.CanInterface() {
+				values = append(values, v.Interface())
+			}
+		}
+	}
+	if c.columnType == ColumnTypeJSON {
+		if len(values) == 0 {
+			return nil, nil
+		}
+		data, err := json.Marshal(values)
+		return string(data), err
+	}
+	if len(values) == 0 || values[0] == nil {
+		return nil, nil
+	}
+
+	value := values[0]
+	switch c.columnType {
+	case ColumnTypeInt:
+		switch v := value.(type) {
+		case int64:
+			return v, nil
+		case float64:
+			return int64(v), nil
+		default:
+			return strconv.ParseInt(fmt.Sprint(v), 10, 64)
+		}
+	case ColumnTypeFloat:
+		switch v := value.(type) {
+		case int64:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		default:
+			return strconv.ParseFloat(fmt.Sprint(v), 64)
+		}
+	case ColumnTypeBool:
+		if v, ok := value.(bool); ok {
+			return v, nil
+		}
+		return strconv.ParseBool(fmt.Sprint(value))
+	case ColumnTypeTime:
+		t, err := time.Parse(time.RFC3339, fmt.Sprint(value))
+		if err != nil {
+			return nil, err
+		}
+		return &t, nil
+	default:
+		// 多个结果以逗号连接
+		parts := make([]string, 0, len(values))
+		for _, v := range values {
+			parts = append(parts, fmt.Sprint(v))
+		}
+		return strings.Join(parts, ","), nil
+	}
+}
+
+// extractColumns 提取所有自定义列，单列失败时写入 NULL，不影响对象本身的同步
+func extractColumns(extractors []columnExtractor, obj *unstructured.Unstructured) map[string]any {
+	columns := make(map[string]any, len(extractors))
+	for _, c := range extractors {
+		value, err := c.extract(obj)
+		if err != nil {
+			klog.V(2).Infof("Extract column %s of %s/%s failed: %v", c.name, obj.GetNamespace(), obj.GetName(), err)
+		}
+		columns[c.name] = value
+	}
+	return columns
+}
+
+// migrateColumns 在 table 中添加缺少的自定义列，已存在的列保持不变
+// 列名与模型内置列的冲突已在 parseColumns 中检查
+func migrateColumns(db *gorm.DB, table string, extractors []columnExtractor) error {
+	if len(extractors) == 0 {
+		return nil
+	}
+	fields := make([]reflect.StructField, 0, len(extractors))
+	for i, c := range extractors {
+		fields = append(fields, reflect.StructField{
+			Name: "Column" + strconv.Itoa(i),
+			Type: c.goType(),
+			Tag:  c.tag(),
+		})
+	}
+	return db.Table(table).AutoMigrate(reflect.New(reflect.StructOf(fields)).Interface())
+}
+
+// values 返回模型除主键外所有列的值，并合并自定义列，
+// 模型和自定义列在同一条语句中写入，使用相同的 ResourceVersion 条件和事务
+func (d *dao) values(ctx context.Context, db *gorm.DB, model BaseModel) (map[string]any, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+	value := reflect.ValueOf(model)
+	values := make(map[string]any, len(stmt.Schema.DBNames)+len(d.columns))
+	for _, field := range stmt.Schema.Fields {
+		if field.DBName == "" || field.PrimaryKey {
+			continue
+		}
+		values[field.DBName], _ = field.ValueOf(ctx, value)
+	}
+	// 按 map 写入时 gorm 不会自动填充时间
+	now := time.Now()
+	if createdAt, _ := values[columnCreatedAt].(time.Time); createdAt.IsZero() {
+		values[columnCreatedAt] = now
+	}
+	values[columnUpdatedAt] = now
+	for name, v := range model.GetColumns() {
+		values[name] = v
+	}
+	return values, nil
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+// Config 启动配置，支持 YAML 和 JSON 两种格式
+type Config struct {
+	ClusterID         string                    `json:"clusterID"`
+	Kubeconfig        string                    `json:"kubeconfig,omitempty"`
+	Context           string                    `json:"context,omitempty"`
+	InCluster         bool                      `json:"inCluster,omitempty"`
+	LeaderElection    LeaderElectionConfig      `json:"leaderElection,omitempty"`
+	DSN               string                    `json:"dsn"`
+	Database          DatabaseConfig            `json:"database,omitempty"`
+	MetricsAddr       string                    `json:"metricsAddr,omitempty"`
+	AuditInterval     *metav1.Duration          `json:"auditInterval,omitempty"`
+	DiscoveryInterval *metav1.Duration          `json:"discoveryInterval,omitempty"`
+	Clusters          []ClusterConfig           `json:"clusters,omitempty"`
+	Hub               *HubConfig                `json:"hub,omitempty"`
+	Whitelist         []string                  `json:"whitelist"`
+	Exclude           []string                  `json:"exclude,omitempty"`
+	Namespaces        *NamespaceConfig          `json:"namespaces,omitempty"`
+	Transform         *TransformConfig          `json:"transform,omitempty"`
+	Secrets           *SecretConfig             `json:"secrets,omitempty"`
+	Dependencies      []DependencyConfig        `json:"dependencies,omitempty"`
+	Resources         map[string]ResourceConfig `json:"resources,omitempty"`
+}
+
+// ClusterConfig 多集群模式下的单个集群，白名单、依赖和资源选项在所有集群间共享
+type ClusterConfig struct {
+	ID         string `json:"id"`
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	Context    string `json:"context,omitempty"`
+	InCluster  bool   `json:"inCluster,omitempty"`
+}
+
+// LeaderElectionConfig 选主使用的 Lease 配置，Namespace 为空时使用 Pod 所在命名空间
+type LeaderElectionConfig struct {
+	Namespace string `json:"namespace,omitempty"`
+	LeaseName string `json:"leaseName,omitempty"`
+}
+
+// DependencyConfig 依赖边，Resource 依赖 DependsOn 中的所有资源，格式同 Resources 的键
+type DependencyConfig struct {
+	Resource  string   `json:"resource"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+// ResourceConfig 单个资源的可选配置，键为 group/resource 或 group/version/resource，
+// 版本被忽略，对资源的所有版本生效，首选版本变化后仍然适用
+type ResourceConfig struct {
+	ResyncPeriod  *metav1.Duration `json:"resyncPeriod,omitempty"`
+	Workers       int              `json:"workers,omitempty"`
+	AuditInterval *metav1.Duration `json:"auditInterval,omitempty"`
+	// History 开启后每次变更都会向历史表追加一个版本
+	History bool `json:"history,omitempty"`
+	// DeleteMode 删除模式，tombstone（默认）保留最终状态和删除时间，hard 直接删除记录
+	DeleteMode string `json:"deleteMode,omitempty"`
+	// TombstoneRetention 墓碑保留时间，超过后永久删除，为空表示永久保留
+	TombstoneRetention *metav1.Duration `json:"tombstoneRetention,omitempty"`
+	// Namespaces 命名空间范围，设置后替代全局配置
+	Namespaces *NamespaceConfig `json:"namespaces,omitempty"`
+	// LabelSelector/FieldSelector 只同步匹配的对象，由 API Server 过滤，对象不再匹配时从数据库中删除
+	LabelSelector string `json:"labelSelector,omitempty"`
+	FieldSelector string `json:"fieldSelector,omitempty"`
+	// Transform 写入缓存和数据库前裁剪对象，设置后替代全局配置
+	Transform *TransformConfig `json:"transform,omitempty"`
+	// Columns 自定义列，列名 -> JSONPath 表达式和类型，如 .spec.replicas (int)
+	Columns map[string]string `json:"columns,omitempty"`
+}
+
+// LoadConfig 读取并校验配置文件
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config %s: %w", path, err)
+	}
+	cfg := &Config{}
+	// yaml.UnmarshalStrict 同时兼容 JSON
+	if err = yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	if err = cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate 校验配置：必填字段、GVR 格式、依赖是否在白名单中以及循环依赖
+func (c *Config) Validate() error {
+	if len(c.Clusters) == 0 && c.ClusterID == "" && c.Hub == nil {
+		return fmt.Errorf("clusterID, clusters or hub is required")
+	}
+	if c.ClusterID == "" && (c.Kubeconfig != "" || c.Context != "" || c.InCluster) {
+		return fmt.Errorf("kubeconfig, context and inCluster require clusterID")
+	}
+	if len(c.Clusters) > 0 && (c.ClusterID != "" || c.Kubeconfig != "" || c.Context != "" || c.InCluster) {
+		return fmt.Errorf("clusterID, kubeconfig, context and inCluster must be set per cluster when clusters is used")
+	}
+	ids := make(map[string]struct{}, len(c.Clusters))
+	for i, cluster := range c.Clusters {
+		if cluster.ID == "" {
+			return fmt.Errorf("clusters[%d]: id is required", i)
+		}
+		if _, ok := ids[cluster.ID]; ok {
+			return fmt.Errorf("clusters[%d]: duplicate id %q", i, cluster.ID)
+		}
+		ids[cluster.ID] = struct{}{}
+		if cluster.InCluster && (cluster.Kubeconfig != "" || cluster.Context != "") {
+			return fmt.Errorf("clusters %s: kubeconfig/context and inCluster are mutually exclusive", cluster.ID)
+		}
+	}
+	if c.Hub != nil {
+		if err := c.Hub.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.DSN == "" {
+		return fmt.Errorf("dsn is required")
+	}
+	if err := c.Database.Validate(); err != nil {
+		return err
+	}
+	if c.InCluster && (c.Kubeconfig != "" || c.Context != "") {
+		return fmt.Errorf("kubeconfig/context and inCluster are mutually exclusive")
+	}
+	if c.AuditInterval != nil && c.AuditInterval.Duration < 0 {
+		return fmt.Errorf("auditInterval must not be negative")
+	}
+	if c.DiscoveryInterval != nil && c.DiscoveryInterval.Duration < 0 {
+		return fmt.Errorf("discoveryInterval must not be negative")
+	}
+	if len(c.Whitelist) == 0 {
+		return fmt.Errorf("whitelist is empty")
+	}
+	if c.Namespaces != nil {
+		if err := c.Namespaces.Validate(); err != nil {
+			return fmt.Errorf("namespaces: %w", err)
+		}
+	}
+
+	if _, err := newObjectTransform(c.Transform); err != nil {
+		return fmt.Errorf("transform: %w", err)
+	}
+	if c.Secrets != nil {
+		if err := c.Secrets.Validate(); err != nil {
+			return fmt.Errorf("secrets: %w", err)
+		}
+	}
+
+	filter, err := c.ResourceFilter()
+	if err != nil {
+		return err
+	}
+	lookup := func(s string) (schema.GroupResource, error) {
+		gr, err := ParseGroupResource(s)
+		if err != nil {
+			return gr, err
+		}
+		if !filter.MayMatchGroupResource(gr) {
+			return gr, fmt.Errorf("unknown resource %q: not in whitelist", s)
+		}
+		return gr, nil
+	}
+
+	for _, dep := range c.Dependencies {
+		if _, err = lookup(dep.Resource); err != nil {
+			return fmt.Errorf("dependencies: %w", err)
+		}
+		for _, d := range dep.DependsOn {
+			if _, err = lookup(d); err != nil {
+				return fmt.Errorf("dependencies of %s: %w", dep.Resource, err)
+			}
+		}
+	}
+	if _, err = c.DependencyMap(); err != nil {
+		return err
+	}
+
+	keys := make(map[schema.GroupResource]string, len(c.Resources))
+	for key, opt := range c.Resources {
+		gr, err := lookup(key)
+		if err != nil {
+			return fmt.Errorf("resources: %w", err)
+		}
+		if other, ok := keys[gr]; ok {
+			return fmt.Errorf("resources: %s and %s refer to the same resource", other, key)
+		}
+		keys[gr] = key
+		if opt.Workers < 0 {
+			return fmt.Errorf("resources %s: workers must not be negative", key)
+		}
+		if opt.ResyncPeriod != nil && opt.ResyncPeriod.Duration < 0 {
+			return fmt.Errorf("resources %s: resyncPeriod must not be negative", key)
+		}
+		if opt.AuditInterval != nil && opt.AuditInterval.Duration < 0 {
+			return fmt.Errorf("resources %s: auditInterval must not be negative", key)
+		}
+		switch opt.DeleteMode {
+		case "", DeleteModeTombstone:
+		case DeleteModeHard:
+			if opt.TombstoneRetention != nil {
+				return fmt.Errorf("resources %s: tombstoneRetention requires deleteMode %s", key, DeleteModeTombstone)
+			}
+		default:
+			return fmt.Errorf("resources %s: unknown deleteMode %q", key, opt.DeleteMode)
+		}
+		if opt.TombstoneRetention != nil && opt.TombstoneRetention.Duration < 0 {
+			return fmt.Errorf("resources %s: tombstoneRetention must not be negative", key)
+		}
+		if opt.Namespaces != nil {
+			if err = opt.Namespaces.Validate(); err != nil {
+				return fmt.Errorf("resources %s: namespaces: %w", key, err)
+			}
+		}
+		if _, err = newObjectSelector(opt.LabelSelector, opt.FieldSelector); err != nil {
+			return fmt.Errorf("resources %s: %w", key, err)
+		}
+		if _, err = newObjectTransform(opt.Transform); err != nil {
+			return fmt.Errorf("resources %s: transform: %w", key, err)
+		}
+		if _, err = parseColumns(gr, opt.Columns); err != nil {
+			return fmt.Errorf("resources %s: columns: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// ClusterConfigs 返回配置文件中的集群，未配置 clusters 时使用顶层的单集群配置，不包含通过 hub 注册的集群
+func (c *Config) ClusterConfigs() []ClusterConfig {
+	if len(c.Clusters) > 0 {
+		return c.Clusters
+	}
+	if c.ClusterID == "" {
+		return nil
+	}
+	return []ClusterConfig{{
+		ID:         c.ClusterID,
+		Kubeconfig: c.Kubeconfig,
+		Context:    c.Context,
+		InCluster:  c.InCluster,
+	}}
+}
+
+// ResourceFilter 解析白名单和排除规则
+func (c *Config) ResourceFilter() (*ResourceFilter, error) {
+	include, err := parseResourceRules(c.Whitelist)
+	if err != nil {
+		return nil, fmt.Errorf("whitelist: %w", err)
+	}
+	exclude, err := parseExcludeRules(c.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("exclude: %w", err)
+	}
+	return &ResourceFilter{Include: include, Exclude: exclude}, nil
+}
+
+// DependencyMap 解析依赖边并做循环检测，依赖按 GroupResource 记录，与服务端选择的版本无关
+func (c *Config) DependencyMap() (map[schema.GroupResource][]schema.GroupResource, error) {
+	depMap := make(map[schema.GroupResource][]schema.GroupResource)
+	for _, dep := range c.Dependencies {
+		gr, err := ParseGroupResource(dep.Resource)
+		if err != nil {
+			return nil, fmt.Errorf("dependencies: %w", err)
+		}
+		for _, d := range dep.DependsOn {
+			target, err := ParseGroupResource(d)
+			if err != nil {
+				return nil, fmt.Errorf("dependencies of %s: %w", dep.Resource, err)
+			}
+			depMap[gr] = append(depMap[gr], target)
+		}
+	}
+	if HasCycle(depMap) {
+		return nil, fmt.Errorf("dependencies: cyclic dependency detected")
+	}
+	return depMap, nil
+}
+
+// usesNamespaceSelector 全局或任一 GVR 是否配置了命名空间标签选择器
+func (c *Config) usesNamespaceSelector() bool {
+	if c.Namespaces != nil && c.Namespaces.Selector != "" {
+		return true
+	}
+	for _, opt := range c.Resources {
+		if opt.Namespaces != nil && opt.Namespaces.Selector != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ResourceOptions 获取资源对应的配置，未配置时返回零值
+func (c *Config) ResourceOptions(gr schema.GroupResource) ResourceConfig {
+	for key, opt := range c.Resources {
+		if parsed, err := ParseGroupResource(key); err == nil && parsed == gr {
+			return opt
+		}
+	}
+	return ResourceConfig{}
+}
+
+// ResyncPeriodOrDefault 返回配置的 resync 周期，未配置时返回默认值
+func (o ResourceConfig) ResyncPeriodOrDefault() time.Duration {
+	if o.ResyncPeriod == nil {
+		return defaultResyncPeriod
+	}
+	return o.ResyncPeriod.Duration
+}
+
+// WorkersOrDefault 返回配置的 worker 数量，未配置时返回默认值
+func (o ResourceConfig) WorkersOrDefault() int {
+	if o.Workers == 0 {
+		return workerCount
+	}
+	return o.Workers
+}
+
+// AuditIntervalOrDefault 返回审计周期，优先使用 GVR 配置，其次全局配置，0 表示关闭
+func (o ResourceConfig) AuditIntervalOrDefault(global *metav1.Duration) time.Duration {
+	if o.AuditInterval != nil {
+		return o.AuditInterval.Duration
+	}
+	return durationOrDefault(global, defaultAuditInterval)
+}
+
+// ParseGVR 解析 group/version/resource 格式的字符串，core 组可省略 group，如 v1/pods
+func ParseGVR(s string) (schema.GroupVersionResource, error) {
+	parts := strings.Split(strings.TrimSpace(s), "/")
+	var gvr schema.GroupVersionResource
+	switch len(parts) {
+	case 2:
+		gvr = schema.GroupVersionResource{Version: parts[0], Resource: parts[1]}
+	case 3:
+		gvr = schema.GroupVersionResource{Group: parts[0], Version: parts[1], Resource: parts[2]}
+	default:
+		return gvr, fmt.Errorf("invalid GVR %q: expected group/version/resource", s)
+	}
+	if gvr.Version == "" || gvr.Resource == "" {
+		return gvr, fmt.Errorf("invalid GVR %q: version and resource are required", s)
+	}
+	return gvr, nil
+}
+
+// FormatGVR ParseGVR 的逆操作
+func FormatGVR(gvr schema.GroupVersionResource) string {
+	if gvr.Group == "" {
+		return gvr.Version + "/" + gvr.Resource
+	}
+	return gvr.Group + "/" + gvr.Version + "/" + gvr.Resource
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestParseGroupResource(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    schema.GroupResource
+		wantErr bool
+	}{
+		{in: "apps/deployments", want: schema.GroupResource{Group: "apps", Resource: "deployments"}},
+		{in: "apps/v1/deployments", want: schema.GroupResource{Group: "apps", Resource: "deployments"}},
+		{in: "v1/pods", want: schema.GroupResource{Resource: "pods"}},
+		{in: "flowcontrol.apiserver.k8s.io/flowschemas", want: schema.GroupResource{Group: "flowcontrol.apiserver.k8s.io", Resource: "flowschemas"}},
+		{in: "apps/*/deployments", wantErr: true},
+		{in: "all", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseGroupResource(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: unexpected error %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestConfigResourceOptionsByGroupResource(t *testing.T) {
+	cfg := &Config{
+		ClusterID: "test",
+		DSN:       "sqlite://:memory:",
+		Whitelist: []string{"v1/pods", "apps/deployments", "apps/v1/replicasets"},
+		Dependencies: []DependencyConfig{
+			{Resource: "v1/pods", DependsOn: []string{"apps/deployments", "apps/v1beta2/replicasets"}},
+		},
+		Resources: map[string]ResourceConfig{
+			"apps/deployments":    {Workers: 3},
+			"apps/v1/replicasets": {Workers: 4},
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	// 键中的版本被忽略，首选版本变化后仍然生效
+	for gvr, workers := range map[schema.GroupVersionResource]int{
+		{Group: "apps", Version: "v1", Resource: "deployments"}:      3,
+		{Group: "apps", Version: "v1beta2", Resource: "deployments"}: 3,
+		{Group: "apps", Version: "v1beta2", Resource: "replicasets"}: 4,
+	} {
+		if got := cfg.ResourceOptions(gvr.GroupResource()).Workers; got != workers {
+			t.Errorf("%s: expected %d workers, got %d", gvr, workers, got)
+		}
+	}
+
+	depMap, err := cfg.DependencyMap()
+	if err != nil {
+		t.Fatalf("dependency map: %v", err)
+	}
+	deps := depMap[schema.GroupResource{Resource: "pods"}]
+	if len(deps) != 2 || deps[1] != (schema.GroupResource{Group: "apps", Resource: "replicasets"}) {
+		t.Fatalf("unexpected dependencies %v", deps)
+	}
+
+	cfg.Resources["apps/v1/deployments"] = ResourceConfig{}
+	if err = cfg.Validate(); err == nil {
+		t.Fatal("expected duplicate resource keys to be rejected")
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	if _, err := LoadConfig("config.example.yaml"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigRejectsBuiltinColumns(t *testing.T) {
+	tests := []struct {
+		resource string
+		column   string
+		wantErr  bool
+	}{
+		{resource: "v1/pods", column: "node"},
+		{resource: "v1/pods", column: "uid", wantErr: true},
+		{resource: "v1/pods", column: "Raw", wantErr: true},
+		{resource: "v1/pods", column: "created_at", wantErr: true},
+		{resource: "v1/pods", column: "Phase", wantErr: true},
+		{resource: "apps/deployments", column: "replicas", wantErr: true},
+		{resource: "apps/deployments", column: "strategy"},
+	}
+	for _, tt := range tests {
+		cfg := &Config{
+			ClusterID: "test",
+			DSN:       "sqlite://:memory:",
+			Whitelist: []string{tt.resource},
+			Resources: map[string]ResourceConfig{tt.resource: {Columns: map[string]string{tt.column: ".metadata.name"}}},
+		}
+		if err := cfg.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s %s: unexpected error %v", tt.resource, tt.column, err)
+		}
+	}
+}
@@ -2,14 +2,16 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
 	apiserror "k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -28,21 +30,53 @@ type Controller struct {
 	lister     cache.GenericLister
 	queue      workqueue.TypedRateLimitingInterface[string]
 	cm         *ControllerManager
-	dependency []schema.GroupVersionResource
+	dependency []schema.GroupResource
 	ready      bool
 	unit       Unit
+	workers    int
+	// auditInterval 一致性审计周期，0 表示关闭
+	auditInterval time.Duration
+	auditor       auditor
+	// finalStates namespace/name -> DeleteInfo，删除事件中对象的最终状态
+	finalStates sync.Map
+	// pending namespace/name -> 入队后尚未处理的动作，新增优先于更新
+	pending sync.Map
+	// tombstoneRetention 墓碑保留时间，0 表示永久保留
+	tombstoneRetention time.Duration
+	// stop 停止运行中的控制器，未启动时为空
+	stop context.CancelFunc
+	// scope 命名空间范围
+	scope *namespaceScope
+	// selector 标签和字段选择器
+	selector *objectSelector
+	// informerKey 共享 informer 的键，停止时释放
+	informerKey  informerKey
+	registration cache.ResourceEventHandlerRegistration
+	// batcher 批量写入，为空时每个变更单独写入
+	batcher *writeBatcher
 }
 
-func generateKey(action string, obj metav1.Object) string {
-	return action + "/" + obj.GetNamespace() + "/" + obj.GetName()
+// objectKey 返回队列键 namespace/name，同一对象的所有变更共用一个键，
+// 队列保证同一个键同一时间只由一个 worker 处理，动作在处理时根据缓存决定
+func objectKey(namespace, name string) string {
+	return namespace + "/" + name
 }
 
-func parseKey(key string) (string, string, string) {
-	parts := strings.Split(key, "/")
-	if len(parts) != 3 {
-		return "", "", ""
+func parseKey(key string) (string, string, bool) {
+	namespace, name, ok := strings.Cut(key, "/")
+	return namespace, name, ok && name != ""
+}
+
+// enqueue 记录动作并将对象加入队列，删除不需要记录，处理时对象已不在缓存中
+func (c *Controller) enqueue(action, namespace, name string) {
+	key := objectKey(namespace, name)
+	switch action {
+	case ActionAdd:
+		c.pending.Store(key, ActionAdd)
+	case ActionUpdate:
+		c.pending.LoadOrStore(key, ActionUpdate)
 	}
-	return parts[0], parts[1], parts[2]
+	c.queue.Add(key)
 }
 
 func (c *Controller) Namespaced() bool {
@@ -84,62 +118,261 @@ func (c *Controller) GetObj(gvr schema.GroupVersionResource, namespace, name str
 	return ojb.(*unstructured.Unstructured), nil
 }
 
+// list 返回缓存中在同步范围内的对象
+func (c *Controller) list() ([]runtime.Object, error) {
+	objs, err := c.lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	filtered := objs[:0]
+	for _, obj := range objs {
+		if c.inScope(obj.(*unstructured.Unstructured)) {
+			filtered = append(filtered, obj)
+		}
+	}
+	return filtered, nil
+}
+
+// inScope 检查对象是否在同步范围内
+func (c *Controller) inScope(obj *unstructured.Unstructured) bool {
+	return c.scope.Match(obj.GetNamespace())
+}
+
 func (c *Controller) onAdd(obj interface{}) {
 	uObj := obj.(*unstructured.Unstructured)
-	log.Println(c.name + generateKey(ActionAdd, uObj))
-	c.queue.Add(generateKey(ActionAdd, uObj))
+	if !c.inScope(uObj) {
+		return
+	}
+	log.Println(c.name + ActionAdd + "/" + objectKey(uObj.GetNamespace(), uObj.GetName()))
+	c.enqueue(ActionAdd, uObj.GetNamespace(), uObj.GetName())
 }
 
 func (c *Controller) onUpdate(oldObj, newObj interface{}) {
 	newObject := newObj.(*unstructured.Unstructured)
 	oldObject := oldObj.(*unstructured.Unstructured)
+	if !c.inScope(newObject) {
+		return
+	}
 	if !c.unit.GetNeedUpdate(oldObject, newObject) {
 		return
 	}
-	log.Println(c.name + generateKey(ActionUpdate, newObject))
-	c.queue.Add(generateKey(ActionUpdate, newObject))
+	log.Println(c.name + ActionUpdate + "/" + objectKey(newObject.GetNamespace(), newObject.GetName()))
+	c.enqueue(ActionUpdate, newObject.GetNamespace(), newObject.GetName())
 }
 
 func (c *Controller) onDelete(obj interface{}) {
+	reason := DeleteReasonWatch
 	if deleted, ok := obj.(cache.DeletedFinalStateUnknown); ok {
 		obj = deleted.Obj
+		reason = DeleteReasonFinalStateUnknown
 	}
-	uObj := obj.(*unstructured.Unstructured)
-	log.Println(c.name + generateKey(ActionDelete, uObj))
-	c.queue.Add(generateKey(ActionDelete, uObj))
+	uObj, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	// 对象修改后不再匹配选择器时 watch 也会返回删除事件
+	if reason == DeleteReasonWatch && uObj.GetDeletionTimestamp() == nil && !c.selector.Matches(uObj) {
+		reason = DeleteReasonFiltered
+	}
+	// 保存最终状态，处理队列时对象已不在缓存中
+	key := objectKey(uObj.GetNamespace(), uObj.GetName())
+	c.finalStates.Store(key, DeleteInfo{Final: uObj, Reason: reason})
+	log.Println(c.name + ActionDelete + "/" + key)
+	c.enqueue(ActionDelete, uObj.GetNamespace(), uObj.GetName())
 }
 
-func (c *Controller) Run(ctx context.Context) {
-
-	for _, storage := range c.unit.GetStorage() {
+// deleteInfo 返回 onDelete 保存的最终状态，没有时只记录原因
+func (c *Controller) deleteInfo(namespace, name string) DeleteInfo {
+	if info, ok := c.finalStates.Load(objectKey(namespace, name)); ok {
+		return info.(DeleteInfo)
+	}
+	return DeleteInfo{Reason: DeleteReasonNotFound}
+}
 
-		err := storage.AutoMigrate(ctx)
-		if err != nil {
-			log.Println(err)
-			continue
-		}
+func (c *Controller) Run(ctx context.Context) {
+	if err := c.Migrate(ctx); err != nil {
+		log.Println(err)
 	}
 
 	defer c.queue.ShutDown()
 
 	stopCh := ctx.Done()
 
-	go c.informer.Informer().Run(stopCh)
-	hasSynced := []cache.InformerSynced{c.informer.Informer().HasSynced}
-	for _, gvr := range c.dependency {
-		hasSynced = append(hasSynced, c.cm.GetController(gvr).GetInformer().Informer().HasSynced)
+	// informer 可能被其他控制器或依赖查询共享，在管理器的运行 context 下运行
+	c.cm.mu.Lock()
+	runCtx := c.cm.runCtx
+	if runCtx == nil {
+		runCtx = ctx
 	}
-	if !cache.WaitForCacheSync(stopCh, hasSynced...) {
+	c.cm.startNamespaceInformer(runCtx)
+	c.cm.mu.Unlock()
+	if c.scope.hasSelector() && !c.cm.waitForNamespaces(stopCh) {
+		klog.Error("Timed out waiting for namespace cache to sync")
+		return
+	}
+	c.cm.runInformer(runCtx, c.informer.Informer())
+	if !c.WaitForCacheSync(stopCh) {
 		klog.Error("Timed out waiting for caches to sync")
 		return
 	}
-	c.ready = true
 	fmt.Printf("Controller %s is ready\n", c.name)
-	for i := 0; i < workerCount; i++ {
-		go wait.Until(c.runWorker, time.Second, stopCh)
+	if _, err := c.Reconcile(ctx); err != nil {
+		klog.Errorf("Reconcile %s failed: %v", c.name, err)
+	}
+	var wg sync.WaitGroup
+	goroutine := func(fn func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+	if c.batcher != nil {
+		goroutine(func() { c.batcher.Run(ctx) })
+	}
+	for i := 0; i < c.workers; i++ {
+		goroutine(func() { wait.Until(c.runWorker, time.Second, stopCh) })
+	}
+	if c.auditInterval > 0 {
+		goroutine(func() { c.runAuditor(ctx, c.auditInterval) })
+	}
+	if c.tombstoneRetention > 0 {
+		goroutine(func() {
+			wait.UntilWithContext(ctx, c.purgeTombstones, tombstonePurgeInterval(c.tombstoneRetention))
+		})
 	}
 
 	<-stopCh
+	// 关闭队列后 worker 处理完已入队的键再退出，之后写入 batcher 中剩余的变更，返回时不再有写入
+	c.queue.ShutDown()
+	wg.Wait()
+	if c.batcher != nil {
+		c.batcher.Flush(context.Background())
+	}
+}
+
+// migrateMu 多个集群共享表，串行执行迁移避免并发建表冲突
+var migrateMu sync.Mutex
+
+// Migrate 对所有存储执行建表/迁移
+func (c *Controller) Migrate(ctx context.Context) error {
+	migrateMu.Lock()
+	defer migrateMu.Unlock()
+	var errs []error
+	for _, storage := range c.unit.GetStorage() {
+		if err := storage.AutoMigrate(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("migrate %s: %w", c.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WaitForCacheSync 等待自身及依赖的 informer 同步完成，informer 需要已经启动
+func (c *Controller) WaitForCacheSync(stopCh <-chan struct{}) bool {
+	hasSynced := []cache.InformerSynced{c.informer.Informer().HasSynced}
+	for _, gr := range c.dependency {
+		// 依赖的 API 不存在时不等待
+		dep := c.cm.GetControllerFor(gr)
+		if dep == nil {
+			klog.Warningf("Dependency %s of %s is not served, skip waiting", gr, c.name)
+			continue
+		}
+		hasSynced = append(hasSynced, dep.GetInformer().Informer().HasSynced)
+	}
+	if c.scope.hasSelector() {
+		c.cm.mu.Lock()
+		hasSynced = append(hasSynced, c.cm.namespaceInformer().Informer().HasSynced)
+		c.cm.mu.Unlock()
+	}
+	if !cache.WaitForCacheSync(stopCh, hasSynced...) {
+		return false
+	}
+	c.ready = true
+	return true
+}
+
+// purgeTombstones 永久删除超过保留时间的墓碑
+func (c *Controller) purgeTombstones(ctx context.Context) {
+	before := time.Now().Add(-c.tombstoneRetention)
+	for _, storage := range c.unit.GetStorage() {
+		count, err := storage.Purge(ctx, before)
+		if err != nil {
+			klog.Errorf("Purge tombstones of %s failed: %v", c.name, err)
+			continue
+		}
+		if count > 0 {
+			klog.Infof("Purged %d tombstones of %s deleted before %s", count, c.name, before.Format(time.RFC3339))
+		}
+	}
+}
+
+// tombstonePurgeInterval 清理周期，最长一小时
+func tombstonePurgeInterval(retention time.Duration) time.Duration {
+	if retention < time.Hour {
+		return retention
+	}
+	return time.Hour
+}
+
+// ReconcileResult 启动对账结果
+type ReconcileResult struct {
+	Cached  int
+	Stored  int
+	Removed int
+}
+
+// Reconcile 对比 informer 缓存和存储，删除缓存中已不存在的对象对应的记录，需要在缓存同步完成后调用
+func (c *Controller) Reconcile(ctx context.Context) (ReconcileResult, error) {
+	var result ReconcileResult
+	objs, err := c.list()
+	if err != nil {
+		return result, err
+	}
+	result.Cached = len(objs)
+	uids := make(map[string]struct{}, len(objs))
+	for _, obj := range objs {
+		uids[string(obj.(*unstructured.Unstructured).GetUID())] = struct{}{}
+	}
+
+	for _, storage := range c.unit.GetStorage() {
+		models, err := storage.Find(ctx)
+		if err != nil {
+			return result, fmt.Errorf("reconcile %s: %w", c.name, err)
+		}
+		result.Stored += len(models)
+		for _, model := range models {
+			uid := model.GetUID()
+			if uid == "" {
+				continue
+			}
+			if _, ok := uids[uid]; ok {
+				continue
+			}
+			if err = storage.DeleteByUID(ctx, uid, DeleteInfo{Reason: DeleteReasonReconcile}); err != nil {
+				return result, fmt.Errorf("reconcile %s: delete %s: %w", c.name, uid, err)
+			}
+			result.Removed++
+		}
+	}
+	klog.Infof("Reconciled %s: %d objects in cache, %d rows in storage, %d stale rows removed",
+		c.name, result.Cached, result.Stored, result.Removed)
+	return result, nil
+}
+
+// Resync 将 informer 缓存中的全部对象写入存储，返回处理的对象数
+func (c *Controller) Resync(ctx context.Context) (int, error) {
+	objs, err := c.list()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, obj := range objs {
+		if err = c.unit.OnAdd(ctx, c, obj.(*unstructured.Unstructured)); err != nil {
+			return count, fmt.Errorf("resync %s: %w", c.name, err)
+		}
+		count++
+	}
+	return count, nil
 }
 
 func (c *Controller) runWorker() {
@@ -152,45 +385,112 @@ func (c *Controller) processNextItem() bool {
 	if quit {
 		return false
 	}
-	defer c.queue.Done(key)
+	op, ok := c.resolve(key)
+	if !ok {
+		c.queue.Done(key)
+		return true
+	}
+	// 开启批量写入时由 batcher 在事务提交后确认
+	if c.batcher != nil {
+		c.batcher.Add(op)
+		return true
+	}
+	c.finish(op, c.apply(context.Background(), op))
+	return true
+}
+
+// resolve 解析队列键并根据缓存决定动作：对象不在缓存中时删除，不在同步范围内时按过滤删除，
+// 否则新增或更新，同名对象被删除后重建时先删除旧对象。
+// 无效的键直接丢弃，读取失败时重新入队，两种情况均返回 false
+func (c *Controller) resolve(key string) (*writeOp, bool) {
 	// 解析队列键
-	action, namespace, name := parseKey(key)
-	if action == "" || name == "" {
+	namespace, name, ok := parseKey(key)
+	if !ok {
 		c.queue.Forget(key)
-		return true
+		return nil, false
 	}
-	var obj runtime.Object
-	var err error
-	if c.namespaced {
-		obj, err = c.lister.ByNamespace(namespace).Get(name)
-	} else {
-		obj, err = c.lister.Get(name)
+	action := ActionUpdate
+	if pending, loaded := c.pending.LoadAndDelete(key); loaded {
+		action = pending.(string)
 	}
+	obj, err := c.get(namespace, name)
 	log.Println(key)
+	op := &writeOp{key: key, action: action, namespace: namespace, name: name, generation: nextGeneration()}
 	if err != nil {
 		if apiserror.IsNotFound(err) {
-			action = ActionDelete
+			op.action = ActionDelete
+			op.info = c.deleteInfo(namespace, name)
 		} else {
+			if action == ActionAdd {
+				c.pending.Store(key, action)
+			}
 			c.queue.AddRateLimited(key)
-			return true
+			return nil, false
+		}
+	} else if uObj := obj.(*unstructured.Unstructured); !c.inScope(uObj) {
+		// 对象已不在同步范围内，从存储中删除
+		op.action = ActionDelete
+		op.info = DeleteInfo{Final: uObj, Reason: DeleteReasonFiltered}
+	} else {
+		op.obj = uObj
+		if value, deleted := c.finalStates.Load(key); deleted {
+			info := value.(DeleteInfo)
+			if info.Final != nil && info.Final.GetUID() != uObj.GetUID() {
+				op.action = ActionAdd
+				op.replaced = &info
+			} else {
+				// 删除事件之后对象仍在缓存中，最终状态已过期
+				c.finalStates.CompareAndDelete(key, value)
+			}
 		}
 	}
-	ctx := context.Background()
-	switch action {
+	return op, true
+}
+
+// get 从缓存中读取对象
+func (c *Controller) get(namespace, name string) (runtime.Object, error) {
+	if c.namespaced {
+		return c.lister.ByNamespace(namespace).Get(name)
+	}
+	return c.lister.Get(name)
+}
+
+// apply 将变更写入存储
+func (c *Controller) apply(ctx context.Context, op *writeOp) error {
+	ctx = withGeneration(ctx, op.generation)
+	switch op.action {
 	case ActionAdd:
-		err = c.unit.OnAdd(ctx, c, obj.(*unstructured.Unstructured))
+		if op.replaced != nil {
+			// 新对象写入前删除旧对象，按名称删除不会影响新对象
+			if err := c.unit.OnDelete(ctx, c.unit.GetStorage(), op.namespace, op.name, *op.replaced); err != nil {
+				return err
+			}
+		}
+		return c.unit.OnAdd(ctx, c, op.obj)
 	case ActionUpdate:
-		err = c.unit.OnUpdate(ctx, c, obj.(*unstructured.Unstructured))
+		return c.unit.OnUpdate(ctx, c, op.obj)
 	case ActionDelete:
-		err = c.unit.OnDelete(ctx, c.unit.GetStorage(), namespace, name)
+		return c.unit.OnDelete(ctx, c.unit.GetStorage(), op.namespace, op.name, op.info)
 	default:
-		err = fmt.Errorf("unknown action: %s", action)
+		return fmt.Errorf("unknown action: %s", op.action)
+	}
+}
+
+// finish 根据写入结果确认或重新入队变更的队列键
+func (c *Controller) finish(op *writeOp, err error) {
+	if err == nil {
+		// 只清理已写入的最终状态，处理期间新的删除事件保存的状态保留到下次处理
+		key := objectKey(op.namespace, op.name)
+		if op.action == ActionDelete {
+			c.finalStates.CompareAndDelete(key, op.info)
+		} else if op.replaced != nil {
+			c.finalStates.CompareAndDelete(key, *op.replaced)
+		}
 	}
 	if err != nil {
-		c.queue.AddRateLimited(key)
-		return true
+		c.queue.AddRateLimited(op.key)
+	} else {
+		c.queue.Forget(op.key)
 	}
-
-	c.queue.Forget(key)
-	return true
+	c.queue.Done(op.key)
 }
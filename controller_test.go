@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// newTestController 创建使用本地缓存和 Pod Dao 的控制器，不启动 informer
+func newTestController(t *testing.T, d Dao) (*Controller, cache.Indexer) {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	scope, err := newNamespaceScope(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selector, err := newObjectSelector("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Controller{
+		name:       "test",
+		clusterID:  "test",
+		gvr:        CoreV1Pod,
+		namespaced: true,
+		lister:     cache.NewGenericLister(indexer, CoreV1Pod.GroupResource()),
+		queue:      workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[string]()),
+		unit:       NewBase("test", CoreV1Pod, true, WithStorage(d)),
+		scope:      scope,
+		selector:   selector,
+	}
+	t.Cleanup(c.queue.ShutDown)
+	return c, indexer
+}
+
+// deletedReason 返回记录的删除原因，记录不存在时失败
+func deletedReason(t *testing.T, db *gorm.DB, d Dao, uid string) string {
+	t.Helper()
+	row := map[string]any{}
+	err := db.Table(d.TableName(context.Background())).Where(columnEq(columnUID, uid)).Take(&row).Error
+	if err != nil {
+		t.Fatalf("find %s: %v", uid, err)
+	}
+	reason, _ := row["DeletedReason"].(string)
+	return reason
+}
+
+func TestQueueKeyedByObject(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDao(t, newTestDB(t))
+	c, indexer := newTestController(t, d)
+
+	pod := newTestPod("web", "uid-1", "1")
+	updated := newTestPod("web", "uid-1", "2")
+	_ = unstructured.SetNestedField(updated.Object, "node-2", "spec", "nodeName")
+	if err := indexer.Add(updated); err != nil {
+		t.Fatal(err)
+	}
+	c.onAdd(pod)
+	c.onUpdate(pod, updated)
+	if c.queue.Len() != 1 {
+		t.Fatalf("expected one key for the object, got %d", c.queue.Len())
+	}
+
+	key, _ := c.queue.Get()
+	op, ok := c.resolve(key)
+	if !ok || op.action != ActionAdd || op.obj.GetResourceVersion() != "2" {
+		t.Fatalf("unexpected op %+v", op)
+	}
+	// 处理期间的新事件等待当前处理完成，不会被其他 worker 同时取出
+	c.onUpdate(updated, pod)
+	if c.queue.Len() != 0 {
+		t.Fatal("key handed out while it is being processed")
+	}
+	c.finish(op, c.apply(ctx, op))
+	if c.queue.Len() != 1 {
+		t.Fatal("event during processing was dropped")
+	}
+	stored, err := d.First(ctx, "default", "web")
+	if err != nil || stored.GetResourceVersion() != "2" {
+		t.Fatalf("unexpected record: %v %v", stored, err)
+	}
+
+	// 对象已从缓存删除时按删除处理
+	if err = indexer.Delete(updated); err != nil {
+		t.Fatal(err)
+	}
+	c.onDelete(updated)
+	if !c.processNextItem() {
+		t.Fatal("queue shut down")
+	}
+	if _, err = d.First(ctx, "default", "web"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expected the record to be deleted, got %v", err)
+	}
+	if _, ok = c.finalStates.Load(objectKey("default", "web")); ok {
+		t.Fatal("final state not cleared after the delete was written")
+	}
+}
+
+func TestQueueRecreatedObject(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	d := newTestDao(t, db)
+	c, indexer := newTestController(t, d)
+
+	old := newTestPod("web", "uid-1", "1")
+	if _, err := d.Upsert(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	// 删除和重建的事件在处理前合并为一个键
+	recreated := newTestPod("web", "uid-2", "3")
+	if err := indexer.Add(recreated); err != nil {
+		t.Fatal(err)
+	}
+	c.onDelete(old)
+	c.onAdd(recreated)
+	if c.queue.Len() != 1 {
+		t.Fatalf("expected one key for the object, got %d", c.queue.Len())
+	}
+	if !c.processNextItem() {
+		t.Fatal("queue shut down")
+	}
+
+	if reason := deletedReason(t, db, d, "uid-1"); reason != DeleteReasonWatch {
+		t.Fatalf("old object not tombstoned, reason %q", reason)
+	}
+	stored, err := d.First(ctx, "default", "web")
+	if err != nil || stored.GetUID() != "uid-2" {
+		t.Fatalf("recreated object not stored: %v %v", stored, err)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	d := newTestDao(t, db)
+	c, indexer := newTestController(t, d)
+	scope, err := newNamespaceScope(&NamespaceConfig{Exclude: []string{"kube-system"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.scope = scope
+
+	system := newTestPod("dns", "uid-dns", "1")
+	system.SetNamespace("kube-system")
+	for _, pod := range []*unstructured.Unstructured{
+		newTestPod("kept", "uid-kept", "1"),
+		newTestPod("stale", "uid-stale", "1"),
+		newTestPod("recreated", "uid-old", "1"),
+		system,
+	} {
+		if _, err = d.Upsert(ctx, pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, pod := range []*unstructured.Unstructured{
+		newTestPod("kept", "uid-kept", "1"),
+		newTestPod("recreated", "uid-new", "2"),
+		newTestPod("missing", "uid-missing", "1"),
+		// 不在同步范围内的对象按已删除处理
+		system,
+	} {
+		if err = indexer.Add(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := c.Reconcile(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Cached != 3 || result.Stored != 4 || result.Removed != 3 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	for _, uid := range []string{"uid-stale", "uid-old", "uid-dns"} {
+		if reason := deletedReason(t, db, d, uid); reason != DeleteReasonReconcile {
+			t.Errorf("%s: expected tombstone with reason %s, got %q", uid, DeleteReasonReconcile, reason)
+		}
+	}
+	if reason := deletedReason(t, db, d, "uid-kept"); reason != "" {
+		t.Errorf("cached object was tombstoned: %q", reason)
+	}
+
+	// 对账后全量写入缓存中的对象，缺少的记录被插入
+	if n, err := c.Resync(ctx); err != nil || n != 3 {
+		t.Fatalf("resync: %d %v", n, err)
+	}
+	for name, uid := range map[string]string{"missing": "uid-missing", "recreated": "uid-new", "kept": "uid-kept"} {
+		stored, err := d.First(ctx, "default", name)
+		if err != nil || stored.GetUID() != uid {
+			t.Errorf("%s: expected %s to be stored, got %v %v", name, uid, stored, err)
+		}
+	}
+	result, err = c.Reconcile(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Stored != 3 || result.Removed != 0 {
+		t.Fatalf("expected storage to match the cache after resync, got %+v", result)
+	}
+}
@@ -9,32 +9,101 @@ import (
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
 )
 
 type Dao interface {
 	AutoMigrate(context.Context) error
 	First(context.Context, string, string) (BaseModel, error)
 	Find(context.Context) ([]BaseModel, error)
-	Save(context.Context, *unstructured.Unstructured) error
+	// Save 按名称更新已有记录，记录不存在或 ResourceVersion 更新时不写入，返回是否写入
+	Save(context.Context, *unstructured.Unstructured) (bool, error)
 	Create(context.Context, *unstructured.Unstructured) error
-	Delete(context.Context, string, string) error
-	// NeedUpdate returns true if the object needs to be updated
-	// It's Useful for update new field
-	// in this function you need to compare the new object and the old data in db
-	// if the new object is different from the old data in db, return true
-	NeedUpdate(ctx context.Context, new *unstructured.Unstructured, old any) bool
+	// Upsert 按 (UID, ClusterID) 插入或更新记录，已有记录的 ResourceVersion 更新时不覆盖，返回是否写入
+	// 同时清除墓碑，对象重新进入同步范围后恢复为未删除
+	Upsert(context.Context, *unstructured.Unstructured) (bool, error)
+	Delete(context.Context, string, string, DeleteInfo) error
+	// DeleteByUID 按 UID 删除当前集群的记录
+	DeleteByUID(context.Context, string, DeleteInfo) error
+	// Purge 永久删除 before 之前删除的墓碑记录，返回删除的行数
+	Purge(ctx context.Context, before time.Time) (int64, error)
+	// PurgeAll 永久删除当前集群的所有记录，包括墓碑，返回删除的行数
+	PurgeAll(ctx context.Context) (int64, error)
+	// GetModel 将对象转换为待持久化的模型，obj 为空时返回空模型
+	GetModel(context.Context, *unstructured.Unstructured) BaseModel
+	TableName(context.Context) string
 }
 
-func NewDao(clusterID string, db *gorm.DB, gvr schema.GroupVersionResource, namespaced bool, realModelFn func(ctx context.Context, model *DynamicModel, obj *unstructured.Unstructured) BaseModel) Dao {
-	return &dao{
+// 删除模式
+const (
+	// DeleteModeTombstone 软删除，保留最终状态、删除时间和原因
+	DeleteModeTombstone = "tombstone"
+	// DeleteModeHard 直接删除记录
+	DeleteModeHard = "hard"
+)
+
+// 删除原因，记录在墓碑的 DeletedReason 列
+const (
+	// DeleteReasonWatch 收到 informer 的删除事件
+	DeleteReasonWatch = "watch"
+	// DeleteReasonFinalStateUnknown 错过删除事件，由重新 list 发现，最终状态可能不是最新的
+	DeleteReasonFinalStateUnknown = "final-state-unknown"
+	// DeleteReasonNotFound 处理队列时对象已不在缓存中
+	DeleteReasonNotFound = "not-found"
+	// DeleteReasonReconcile 启动对账发现对象已不存在
+	DeleteReasonReconcile = "reconcile"
+	// DeleteReasonAudit 周期审计发现对象已不存在，包括同名对象以新 UID 重建后的旧记录
+	DeleteReasonAudit = "audit"
+	// DeleteReasonFiltered 对象仍然存在，但已不在同步范围内
+	DeleteReasonFiltered = "filtered"
+)
+
+// DeleteInfo 删除时可获得的信息，Final 为对象最后已知状态，可能为空
+type DeleteInfo struct {
+	Final  *unstructured.Unstructured
+	Reason string
+}
+
+// DaoOption Dao 选项函数
+type DaoOption func(*dao)
+
+// WithDeleteMode 设置删除模式，默认为 DeleteModeTombstone
+func WithDeleteMode(mode string) DaoOption {
+	return func(d *dao) {
+		d.deleteMode = mode
+	}
+}
+
+// WithSecretPolicy 设置 Secret 的脱敏和加密，只用于 Secret 的 Dao
+func WithSecretPolicy(policy *secretPolicy) DaoOption {
+	return func(d *dao) {
+		d.secrets = policy
+	}
+}
+
+// WithColumns 设置自定义列
+func WithColumns(columns []columnExtractor) DaoOption {
+	return func(d *dao) {
+		d.columns = columns
+	}
+}
+
+func NewDao(clusterID string, db *gorm.DB, gvr schema.GroupVersionResource, namespaced bool, realModelFn func(ctx context.Context, model *DynamicModel, obj *unstructured.Unstructured) BaseModel, opts ...DaoOption) Dao {
+	d := &dao{
 		clusterID:   clusterID,
 		db:          db,
 		gvr:         gvr,
 		namespaced:  namespaced,
 		realModelFn: realModelFn,
+		deleteMode:  DeleteModeTombstone,
 	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
 }
 
 type dao struct {
@@ -43,6 +112,9 @@ type dao struct {
 	gvr         schema.GroupVersionResource
 	namespaced  bool
 	realModelFn func(ctx context.Context, model *DynamicModel, obj *unstructured.Unstructured) BaseModel
+	deleteMode  string
+	secrets     *secretPolicy
+	columns     []columnExtractor
 }
 
 func (d *dao) Find(ctx context.Context) ([]BaseModel, error) {
@@ -52,11 +124,16 @@ func (d *dao) Find(ctx context.Context) ([]BaseModel, error) {
 	sliceType := reflect.SliceOf(modelType)
 	slicePtr := reflect.New(sliceType)
 	// 执行数据库查询
-	if err := d.db.Where("ClusterID = ?", d.clusterID).Find(slicePtr.Interface()).Error; err != nil {
+	if err := conn(ctx, d.db).Table(d.TableName(ctx)).Where(columnEq(columnClusterID, d.clusterID)).Find(slicePtr.Interface()).Error; err != nil {
 		return nil, err
 	}
 
-	return slicePtr.Interface().([]BaseModel), nil
+	slice := slicePtr.Elem()
+	models := make([]BaseModel, 0, slice.Len())
+	for i := 0; i < slice.Len(); i++ {
+		models = append(models, slice.Index(i).Interface().(BaseModel))
+	}
+	return models, nil
 }
 
 func (d *dao) GetModel(ctx context.Context, obj *unstructured.Unstructured) BaseModel {
@@ -69,9 +146,13 @@ func (d *dao) GetModel(ctx context.Context, obj *unstructured.Unstructured) Base
 		labels          string
 		annotations     string
 		createAt        time.Time
+		generation      int64
 	)
 
 	if obj != nil {
+		if d.secrets != nil {
+			obj = d.secrets.redact(obj)
+		}
 		marshalJSON, err := obj.MarshalJSON()
 		if err != nil {
 			return nil
@@ -89,7 +170,14 @@ func (d *dao) GetModel(ctx context.Context, obj *unstructured.Unstructured) Base
 		obj.GetCreationTimestamp()
 		createAt = obj.GetCreationTimestamp().Time
 		uid = string(obj.GetUID())
+		generation = generationFromContext(ctx)
 		raw = string(marshalJSON)
+		if d.secrets != nil {
+			if raw, err = d.secrets.encrypt(raw); err != nil {
+				klog.Errorf("Encrypt %s/%s failed: %v", namespace, name, err)
+				return nil
+			}
+		}
 	}
 
 	baseModel := DynamicModel{
@@ -103,9 +191,15 @@ func (d *dao) GetModel(ctx context.Context, obj *unstructured.Unstructured) Base
 		Annotations:     annotations,
 		Raw:             raw,
 		Version:         d.gvr.Version,
+		Group:           d.gvr.Group,
 		Resource:        d.gvr.Resource,
 		UID:             uid,
 		ResourceVersion: resourceVersion,
+		SyncGeneration:  generation,
+	}
+
+	if obj != nil && len(d.columns) > 0 {
+		baseModel.Columns = extractColumns(d.columns, obj)
 	}
 
 	if d.realModelFn != nil {
@@ -126,7 +220,108 @@ func MustJson(data any) string {
 func (d *dao) AutoMigrate(ctx context.Context) error {
 	model := d.GetModel(ctx, nil)
 	log.Println(MustJson(model))
-	return d.db.Table(model.TableName()).AutoMigrate(d.GetModel(ctx, nil))
+	if err := d.db.Table(model.TableName()).AutoMigrate(d.GetModel(ctx, nil)); err != nil {
+		return err
+	}
+	if err := dropLegacyIndexes(d.db, model.TableName(), model); err != nil {
+		return err
+	}
+	if err := migrateColumns(d.db, model.TableName(), d.columns); err != nil {
+		return err
+	}
+	legacy, group := legacyTable(model, "")
+	return migrateLegacyRows(d.db, legacy, model.TableName(), group, model)
+}
+
+// legacyIndexes 旧版本创建、已被替换的索引，AutoMigrate 只创建新索引不会删除旧索引
+var legacyIndexes = []string{
+	// (UID, ClusterID) 唯一索引，现为 idx_<table>_uid，索引名在 PostgreSQL 和 SQLite 中全库唯一，无法用于多张表
+	"idx_uid",
+}
+
+// dropLegacyIndexes 删除表上的旧索引
+func dropLegacyIndexes(db *gorm.DB, table string, model BaseModel) error {
+	migrator := db.Table(table).Migrator()
+	for _, name := range legacyIndexes {
+		if !migrator.HasIndex(model, name) {
+			continue
+		}
+		klog.Infof("Drop legacy index %s on %s", name, table)
+		if err := migrator.DropIndex(model, name); err != nil {
+			return fmt.Errorf("drop index %s on %s: %w", name, table, err)
+		}
+	}
+	return nil
+}
+
+// legacyTabler 可以返回旧版本表名的模型
+type legacyTabler interface {
+	legacyTableName() string
+	apiGroup() string
+}
+
+// legacyTable 返回模型在旧版本中的表名加上 suffix 以及模型的 API 组，与当前表名相同时返回空
+func legacyTable(model BaseModel, suffix string) (string, string) {
+	m, ok := model.(legacyTabler)
+	if !ok || m.legacyTableName() == model.TableName() {
+		return "", ""
+	}
+	return m.legacyTableName() + suffix, m.apiGroup()
+}
+
+// migrateBatchSize 迁移旧表记录时每批移动的行数
+const migrateBatchSize = 500
+
+// migrateLegacyRows 旧版本不同 API 组的同名资源共用一张表，将旧表中属于 group 的记录分批移动到 table，
+// 按 Raw 中的 apiVersion 区分，旧表中其他组的记录保持不变。model 为旧表记录的模型，用于删除
+func migrateLegacyRows(db *gorm.DB, legacy, table, group string, model any) error {
+	if legacy == "" || !db.Migrator().HasTable(legacy) {
+		return nil
+	}
+	columnTypes, err := db.Migrator().ColumnTypes(table)
+	if err != nil {
+		return err
+	}
+	columns := make(map[string]bool, len(columnTypes))
+	for _, ct := range columnTypes {
+		columns[ct.Name()] = true
+	}
+	cond := clause.Like{Column: clause.Column{Name: columnRaw}, Value: `{"apiVersion":"` + group + `/%`}
+	moved := 0
+	for {
+		var rows []map[string]any
+		if err = db.Table(legacy).Where(cond).Order("id").Limit(migrateBatchSize).Find(&rows).Error; err != nil {
+			return fmt.Errorf("read %s: %w", legacy, err)
+		}
+		if len(rows) == 0 {
+			break
+		}
+		ids := make([]any, 0, len(rows))
+		for _, row := range rows {
+			ids = append(ids, row["id"])
+			for column := range row {
+				if column == "id" || !columns[column] {
+					delete(row, column)
+				}
+			}
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Table(table).Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error; err != nil {
+				return err
+			}
+			return tx.Table(legacy).Unscoped().
+				Where(clause.IN{Column: clause.Column{Name: "id"}, Values: ids}).
+				Delete(model).Error
+		})
+		if err != nil {
+			return fmt.Errorf("move %s rows from %s to %s: %w", group, legacy, table, err)
+		}
+		moved += len(rows)
+	}
+	if moved > 0 {
+		klog.Infof("Moved %d %s rows from %s to %s", moved, group, legacy, table)
+	}
+	return nil
 }
 
 func (d *dao) TableName(ctx context.Context) string {
@@ -134,10 +329,10 @@ func (d *dao) TableName(ctx context.Context) string {
 }
 
 func (d *dao) GetWhere(ctx context.Context, namespace string, name string) *gorm.DB {
-	query := d.db.WithContext(ctx).Table(d.TableName(ctx)).Where("name = ?", name).
-		Where("ClusterID = ?", d.clusterID)
+	query := conn(ctx, d.db).Table(d.TableName(ctx)).Where(columnEq(columnName, name)).
+		Where(columnEq(columnClusterID, d.clusterID))
 	if d.namespaced {
-		query = query.Where("namespace = ?", namespace)
+		query = query.Where(columnEq(columnNamespace, namespace))
 	}
 	return query
 }
@@ -148,23 +343,184 @@ func (d *dao) First(ctx context.Context, namespace string, name string) (BaseMod
 	return model, query.First(model).Error
 }
 
-func (d *dao) Save(ctx context.Context, u *unstructured.Unstructured) error {
+// Save 更新 namespace/name 对应的记录，已有记录比传入对象新时不更新，见 writeGuard
+func (d *dao) Save(ctx context.Context, u *unstructured.Unstructured) (bool, error) {
+	ctx = ensureGeneration(ctx)
 	model := d.GetModel(ctx, u)
+	if model == nil {
+		return false, fmt.Errorf("convert %s/%s to model failed", u.GetNamespace(), u.GetName())
+	}
+	guard := writeGuard(d.TableName(ctx), u.GetResourceVersion(), func(column string) any {
+		if column == columnResourceVersion {
+			return u.GetResourceVersion()
+		}
+		return generationFromContext(ctx)
+	})
 	query := d.GetWhere(ctx, u.GetNamespace(), u.GetName())
-	return query.Updates(model).Error
+	values, err := d.values(ctx, query, model)
+	if err != nil {
+		return false, err
+	}
+	result := query.Model(model).Where(guard).Updates(values)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+	// 没有更新任何行时区分记录不存在和过期写入
+	if _, err := d.First(ctx, u.GetNamespace(), u.GetName()); err == nil {
+		d.rejectStale(u)
+	}
+	return false, nil
 }
 
 func (d *dao) Create(ctx context.Context, u *unstructured.Unstructured) error {
 	model := d.GetModel(ctx, u)
-	return d.db.WithContext(ctx).Table(d.TableName(ctx)).Create(model).Error
+	if model == nil {
+		return fmt.Errorf("convert %s/%s to model failed", u.GetNamespace(), u.GetName())
+	}
+	db := conn(ctx, d.db)
+	values, err := d.values(ctx, db, model)
+	if err != nil {
+		return err
+	}
+	return db.Table(d.TableName(ctx)).Create(values).Error
+}
+
+func (d *dao) Upsert(ctx context.Context, u *unstructured.Unstructured) (bool, error) {
+	ctx = ensureGeneration(ctx)
+	model := d.GetModel(ctx, u)
+	if model == nil {
+		return false, fmt.Errorf("convert %s/%s to model failed", u.GetNamespace(), u.GetName())
+	}
+	db := conn(ctx, d.db)
+	values, err := d.values(ctx, db, model)
+	if err != nil {
+		return false, err
+	}
+	onConflict, err := d.upsertClause(ctx, db, model)
+	if err != nil {
+		return false, err
+	}
+	result := db.Table(d.TableName(ctx)).Clauses(onConflict).Create(values)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		d.rejectStale(u)
+		return false, nil
+	}
+	return true, nil
+}
+
+// rejectStale 记录被拒绝的过期写入
+func (d *dao) rejectStale(u *unstructured.Unstructured) {
+	klog.V(2).Infof("Skip stale write of %s %s/%s at resourceVersion %s", d.gvr.Resource, u.GetNamespace(), u.GetName(), u.GetResourceVersion())
+	staleWrites.WithLabelValues(d.clusterID, d.gvr.String()).Inc()
 }
 
-func (d *dao) Delete(ctx context.Context, namespace string, name string) error {
+// upsertClause 冲突时更新除主键和唯一键外的所有列和自定义列，已有记录比传入对象新时不更新，见 writeGuard
+func (d *dao) upsertClause(ctx context.Context, db *gorm.DB, model BaseModel) (clause.OnConflict, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return clause.OnConflict{}, err
+	}
+	table := d.TableName(ctx)
+	resourceVersion := model.GetResourceVersion()
+	// 条件使用的列放在最后，MySQL 按顺序赋值，前面的条件需要读取旧值
+	last := guardColumn(resourceVersion)
+	columns := make([]string, 0, len(stmt.Schema.DBNames))
+	for _, column := range stmt.Schema.DBNames {
+		switch column {
+		case "id", columnUID, columnClusterID, last:
+			continue
+		}
+		columns = append(columns, column)
+	}
+	for _, c := range d.columns {
+		columns = append(columns, c.name)
+	}
+	columns = append(columns, last)
+	conflict := []clause.Column{{Name: columnUID}, {Name: columnClusterID}}
+
+	if db.Dialector.Name() != "mysql" {
+		guard := writeGuard(table, resourceVersion, func(column string) any {
+			return clause.Column{Table: "excluded", Name: column}
+		})
+		return clause.OnConflict{
+			Columns:   conflict,
+			DoUpdates: clause.AssignmentColumns(columns),
+			Where:     clause.Where{Exprs: []clause.Expression{guard}},
+		}, nil
+	}
+
+	// MySQL 的 ON DUPLICATE KEY UPDATE 不支持 WHERE，逐列判断
+	guard := writeGuard(table, resourceVersion, func(column string) any {
+		return clause.Expr{SQL: "VALUES(?)", Vars: []any{clause.Column{Name: column}}}
+	})
+	assignments := make([]clause.Assignment, 0, len(columns))
+	for _, column := range columns {
+		col := clause.Column{Name: column}
+		assignments = append(assignments, clause.Assignment{
+			Column: col,
+			Value:  clause.Expr{SQL: "IF(?, VALUES(?), ?)", Vars: []any{guard, col, col}},
+		})
+	}
+	return clause.OnConflict{Columns: conflict, DoUpdates: assignments}, nil
+}
+
+func (d *dao) Delete(ctx context.Context, namespace string, name string, info DeleteInfo) error {
+	return d.delete(ctx, d.GetWhere(ctx, namespace, name), info)
+}
+
+func (d *dao) DeleteByUID(ctx context.Context, uid string, info DeleteInfo) error {
+	query := conn(ctx, d.db).Table(d.TableName(ctx)).
+		Where(columnEq(columnUID, uid)).
+		Where(columnEq(columnClusterID, d.clusterID))
+	return d.delete(ctx, query, info)
+}
+
+// delete 按删除模式删除 query 匹配的记录，墓碑模式下在同一条语句中写入最终状态（包括自定义列）、删除时间和原因
+func (d *dao) delete(ctx context.Context, query *gorm.DB, info DeleteInfo) error {
 	model := d.GetModel(ctx, nil)
-	query := d.GetWhere(ctx, namespace, name)
-	return query.Delete(model).Error
+	// 有最终状态时只删除该 UID 的记录，同名的其他记录（如以新 UID 重建的对象）不受影响，
+	// 最终状态包括 UID，写入其他记录会违反 (UID, ClusterID) 唯一索引
+	if info.Final != nil && info.Final.GetUID() != "" {
+		query = query.Where(columnEq(columnUID, string(info.Final.GetUID())))
+	}
+	if d.deleteMode == DeleteModeHard {
+		return query.Unscoped().Delete(model).Error
+	}
+	values := map[string]any{}
+	if info.Final != nil {
+		final := d.GetModel(ctx, info.Final)
+		if final == nil {
+			return fmt.Errorf("convert %s/%s to model failed", info.Final.GetNamespace(), info.Final.GetName())
+		}
+		var err error
+		if values, err = d.values(ctx, query, final); err != nil {
+			return err
+		}
+	}
+	values[columnDeletedAt] = time.Now()
+	values[columnDeletedReason] = info.Reason
+	return query.Session(&gorm.Session{}).Model(model).Updates(values).Error
+}
+
+func (d *dao) Purge(ctx context.Context, before time.Time) (int64, error) {
+	model := d.GetModel(ctx, nil)
+	result := conn(ctx, d.db).Table(d.TableName(ctx)).Unscoped().
+		Where(columnEq(columnClusterID, d.clusterID)).
+		Where(clause.Lt{Column: clause.Column{Name: columnDeletedAt}, Value: before}).
+		Delete(model)
+	return result.RowsAffected, result.Error
 }
 
-func (d *dao) NeedUpdate(ctx context.Context, new *unstructured.Unstructured, old any) bool {
-	return true
+func (d *dao) PurgeAll(ctx context.Context) (int64, error) {
+	model := d.GetModel(ctx, nil)
+	result := conn(ctx, d.db).Table(d.TableName(ctx)).Unscoped().
+		Where(columnEq(columnClusterID, d.clusterID)).
+		Delete(model)
+	return result.RowsAffected, result.Error
 }
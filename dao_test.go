@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// newTestDB 打开内存 SQLite，驱动为纯 Go 实现，CGO_ENABLED=0 时同样可用
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := OpenDatabase(context.Background(), "sqlite://:memory:", DatabaseConfig{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = CloseDatabase(db) })
+	return db
+}
+
+// newTestDao 创建并建表 Pod 的 Dao
+func newTestDao(t *testing.T, db *gorm.DB, opts ...DaoOption) Dao {
+	t.Helper()
+	d := NewDao("test", db, CoreV1Pod, true, nil, opts...)
+	if err := d.AutoMigrate(context.Background()); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+	return d
+}
+
+// newTestPod 构造测试用的 Pod
+func newTestPod(name, uid, resourceVersion string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]interface{}{
+			"name":            name,
+			"namespace":       "default",
+			"uid":             uid,
+			"resourceVersion": resourceVersion,
+		},
+		"spec": map[string]interface{}{
+			"nodeName": "node-1",
+		},
+	}}
+}
+
+func TestDaoSQLite(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDao(t, newTestDB(t))
+
+	written, err := d.Upsert(ctx, newTestPod("web", "uid-1", "10"))
+	if err != nil || !written {
+		t.Fatalf("upsert: written=%v err=%v", written, err)
+	}
+	stored, err := d.First(ctx, "default", "web")
+	if err != nil {
+		t.Fatalf("first: %v", err)
+	}
+	if stored.GetUID() != "uid-1" || stored.GetResourceVersion() != "10" {
+		t.Fatalf("unexpected record: uid=%s rv=%s", stored.GetUID(), stored.GetResourceVersion())
+	}
+	obj, err := stored.ToUnstructured()
+	if err != nil {
+		t.Fatalf("to unstructured: %v", err)
+	}
+	if node, _, _ := unstructured.NestedString(obj.Object, "spec", "nodeName"); node != "node-1" {
+		t.Fatalf("raw not round-tripped, nodeName=%q", node)
+	}
+
+	if err = d.Delete(ctx, "default", "web", DeleteInfo{Reason: DeleteReasonWatch}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err = d.First(ctx, "default", "web"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expected tombstone to be hidden, got %v", err)
+	}
+	n, err := d.Purge(ctx, time.Now().Add(time.Minute))
+	if err != nil || n != 1 {
+		t.Fatalf("purge: n=%d err=%v", n, err)
+	}
+}
+
+func TestDaoRejectsStaleWrites(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDao(t, newTestDB(t))
+	stale := staleWrites.WithLabelValues("test", CoreV1Pod.String())
+	before := testutil.ToFloat64(stale)
+
+	if _, err := d.Upsert(ctx, newTestPod("web", "uid-1", "12")); err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+	written, err := d.Save(ctx, newTestPod("web", "uid-1", "11"))
+	if err != nil || written {
+		t.Fatalf("expected stale save to be rejected: written=%v err=%v", written, err)
+	}
+	written, err = d.Upsert(ctx, newTestPod("web", "uid-1", "11"))
+	if err != nil || written {
+		t.Fatalf("expected stale upsert to be rejected: written=%v err=%v", written, err)
+	}
+	if got := testutil.ToFloat64(stale) - before; got != 2 {
+		t.Fatalf("expected 2 stale writes, got %v", got)
+	}
+	stored, err := d.First(ctx, "default", "web")
+	if err != nil || stored.GetResourceVersion() != "12" {
+		t.Fatalf("stored row overwritten: %v %v", stored, err)
+	}
+
+	// 记录不存在时不算过期写入
+	if written, err = d.Save(ctx, newTestPod("api", "uid-2", "1")); err != nil || written {
+		t.Fatalf("expected save of a missing row to write nothing: written=%v err=%v", written, err)
+	}
+	if got := testutil.ToFloat64(stale) - before; got != 2 {
+		t.Fatalf("missing row counted as stale write, got %v", got)
+	}
+	if written, err = d.Save(ctx, newTestPod("web", "uid-1", "13")); err != nil || !written {
+		t.Fatalf("expected newer save to be written: written=%v err=%v", written, err)
+	}
+}
+
+func TestDaoDropsLegacyIndex(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	d := newTestDao(t, db)
+	table := d.TableName(ctx)
+
+	// 旧版本在 (UID, ClusterID) 上创建的 idx_uid
+	if err := db.Exec(`CREATE UNIQUE INDEX idx_uid ON "` + table + `" ("UID", "ClusterID")`).Error; err != nil {
+		t.Fatalf("create legacy index: %v", err)
+	}
+	if err := d.AutoMigrate(ctx); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+	model := d.GetModel(ctx, nil)
+	migrator := db.Table(table).Migrator()
+	if migrator.HasIndex(model, "idx_uid") {
+		t.Fatal("legacy index not dropped")
+	}
+	if !migrator.HasIndex(model, "idx_"+table+"_uid") {
+		t.Fatal("composite unique index missing")
+	}
+}
+
+func TestDaoColumnsFollowRowGuard(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	columns, err := parseColumns(CoreV1Pod.GroupResource(), map[string]string{"node": ".spec.nodeName"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newTestDao(t, db, WithColumns(columns))
+	node := func() string {
+		t.Helper()
+		row := map[string]any{}
+		if err := db.Table(d.TableName(ctx)).Where(columnEq(columnUID, "uid-1")).Take(&row).Error; err != nil {
+			t.Fatalf("find row: %v", err)
+		}
+		value, _ := row["node"].(string)
+		return value
+	}
+	podOn := func(resourceVersion, nodeName string) *unstructured.Unstructured {
+		pod := newTestPod("web", "uid-1", resourceVersion)
+		_ = unstructured.SetNestedField(pod.Object, nodeName, "spec", "nodeName")
+		return pod
+	}
+
+	if _, err = d.Upsert(ctx, podOn("10", "node-1")); err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+	if got := node(); got != "node-1" {
+		t.Fatalf("column not written on insert, got %q", got)
+	}
+	// 过期写入不更新自定义列
+	if _, err = d.Upsert(ctx, podOn("9", "node-2")); err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+	if _, err = d.Save(ctx, podOn("9", "node-2")); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if got := node(); got != "node-1" {
+		t.Fatalf("stale write updated the column to %q", got)
+	}
+	if _, err = d.Save(ctx, podOn("11", "node-3")); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if got := node(); got != "node-3" {
+		t.Fatalf("column not written on save, got %q", got)
+	}
+
+	// 墓碑记录最终状态的自定义列
+	if err = d.Delete(ctx, "default", "web", DeleteInfo{Final: podOn("12", "node-4"), Reason: DeleteReasonWatch}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if got := node(); got != "node-4" {
+		t.Fatalf("tombstone kept the column %q", got)
+	}
+}
+
+func TestDaoDeleteFinalStateByUID(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	d := newTestDao(t, db)
+
+	// 同名的两条未删除记录：旧对象和以新 UID 重建的对象
+	for _, pod := range []*unstructured.Unstructured{newTestPod("web", "uid-1", "1"), newTestPod("web", "uid-2", "3")} {
+		if _, err := d.Upsert(ctx, pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Delete(ctx, "default", "web", DeleteInfo{Final: newTestPod("web", "uid-1", "2"), Reason: DeleteReasonWatch}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if reason := deletedReason(t, db, d, "uid-1"); reason != DeleteReasonWatch {
+		t.Fatalf("expected uid-1 to be tombstoned, got %q", reason)
+	}
+	if reason := deletedReason(t, db, d, "uid-2"); reason != "" {
+		t.Fatalf("recreated object was tombstoned: %q", reason)
+	}
+	stored, err := d.First(ctx, "default", "web")
+	if err != nil || stored.GetUID() != "uid-2" || stored.GetResourceVersion() != "3" {
+		t.Fatalf("recreated object changed: %v %v", stored, err)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultMaxOpenConns    = 20
+	defaultMaxIdleConns    = 10
+	defaultConnMaxLifetime = 30 * time.Minute
+	defaultConnMaxIdleTime = 5 * time.Minute
+	defaultConnectRetries  = 5
+)
+
+// DatabaseConfig 数据库连接池配置，零值使用默认值
+type DatabaseConfig struct {
+	MaxOpenConns    int              `json:"maxOpenConns,omitempty"`
+	MaxIdleConns    int              `json:"maxIdleConns,omitempty"`
+	ConnMaxLifetime *metav1.Duration `json:"connMaxLifetime,omitempty"`
+	ConnMaxIdleTime *metav1.Duration `json:"connMaxIdleTime,omitempty"`
+	// ConnectRetries 启动时连接失败的重试次数，重试间隔指数增长
+	ConnectRetries int `json:"connectRetries,omitempty"`
+	// Debug 打印所有 SQL
+	Debug bool `json:"debug,omitempty"`
+	// BatchSize 每个控制器批量写入的最大变更数，0 表示关闭批量写入，每个变更单独写入
+	BatchSize int `json:"batchSize,omitempty"`
+	// BatchInterval 批量写入的最长等待时间，默认 1s
+	BatchInterval *metav1.Duration `json:"batchInterval,omitempty"`
+}
+
+// Validate 校验连接池配置
+func (c DatabaseConfig) Validate() error {
+	if c.MaxOpenConns < 0 || c.MaxIdleConns < 0 || c.ConnectRetries < 0 || c.BatchSize < 0 {
+		return fmt.Errorf("database: maxOpenConns, maxIdleConns, connectRetries and batchSize must not be negative")
+	}
+	if c.BatchInterval != nil && c.BatchInterval.Duration <= 0 {
+		return fmt.Errorf("database: batchInterval must be positive")
+	}
+	if c.ConnMaxLifetime != nil && c.ConnMaxLifetime.Duration < 0 {
+		return fmt.Errorf("database: connMaxLifetime must not be negative")
+	}
+	if c.ConnMaxIdleTime != nil && c.ConnMaxIdleTime.Duration < 0 {
+		return fmt.Errorf("database: connMaxIdleTime must not be negative")
+	}
+	return nil
+}
+
+// OpenDatabase 打开共享连接池并确认可连通，失败时按指数退避重试
+func OpenDatabase(ctx context.Context, dsn string, cfg DatabaseConfig) (*gorm.DB, error) {
+	gormConfig := &gorm.Config{Logger: logger.Default.LogMode(logger.Warn)}
+	if cfg.Debug {
+		gormConfig.Logger = logger.Default.LogMode(logger.Info)
+	}
+
+	driver, err := LookupDriver(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	retries := cfg.ConnectRetries
+	if retries == 0 {
+		retries = defaultConnectRetries
+	}
+	backoff := wait.Backoff{
+		Duration: time.Second,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    retries + 1,
+		Cap:      30 * time.Second,
+	}
+
+	var (
+		db      *gorm.DB
+		lastErr error
+		attempt int
+	)
+	err = wait.ExponentialBackoffWithContext(ctx, backoff, func(ctx context.Context) (bool, error) {
+		attempt++
+		db, lastErr = connect(ctx, driver, dsn, gormConfig, cfg)
+		if lastErr != nil {
+			klog.Warningf("Connect to database failed (attempt %d/%d): %v", attempt, retries+1, lastErr)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		if lastErr != nil {
+			return nil, fmt.Errorf("connect to database: %w", lastErr)
+		}
+		return nil, err
+	}
+	return db, nil
+}
+
+func connect(ctx context.Context, driver *Driver, dsn string, gormConfig *gorm.Config, cfg DatabaseConfig) (*gorm.DB, error) {
+	db, err := gorm.Open(driver.Open(dsn), gormConfig)
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	if err = sqlDB.PingContext(ctx); err != nil {
+		_ = sqlDB.Close()
+		return nil, err
+	}
+
+	if driver.SingleConn {
+		sqlDB.SetMaxOpenConns(1)
+		sqlDB.SetMaxIdleConns(1)
+		sqlDB.SetConnMaxLifetime(0)
+		sqlDB.SetConnMaxIdleTime(0)
+		return db, nil
+	}
+	sqlDB.SetMaxOpenConns(orDefault(cfg.MaxOpenConns, defaultMaxOpenConns))
+	sqlDB.SetMaxIdleConns(orDefault(cfg.MaxIdleConns, defaultMaxIdleConns))
+	sqlDB.SetConnMaxLifetime(durationOrDefault(cfg.ConnMaxLifetime, defaultConnMaxLifetime))
+	sqlDB.SetConnMaxIdleTime(durationOrDefault(cfg.ConnMaxIdleTime, defaultConnMaxIdleTime))
+	return db, nil
+}
+
+// CloseDatabase 关闭连接池
+func CloseDatabase(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+func orDefault(v, def int) int {
+	if v == 0 {
+		return def
+	}
+	return v
+}
+
+func durationOrDefault(d *metav1.Duration, def time.Duration) time.Duration {
+	if d == nil {
+		return def
+	}
+	return d.Duration
+}
@@ -1,18 +1,49 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"strings"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
 )
 
+// 列名，与 DynamicModel 和 Revision 的 gorm column 标签保持一致
+const (
+	columnName            = "Name"
+	columnNamespace       = "Namespace"
+	columnUID             = "UID"
+	columnResourceVersion = "ResourceVersion"
+	columnSyncGeneration  = "SyncGeneration"
+	columnClusterID       = "ClusterID"
+	columnCreatedAt       = "created_at"
+	columnUpdatedAt       = "updated_at"
+	columnDeletedAt       = "deleted_at"
+	columnDeletedReason   = "DeletedReason"
+	columnRaw             = "Raw"
+	columnAction          = "Action"
+	columnTimestamp       = "Timestamp"
+)
+
+// columnEq 生成列名带引号的等值条件，保证在大小写敏感的数据库（如 PostgreSQL）上同样可用
+func columnEq(column string, value any) clause.Eq {
+	return clause.Eq{Column: clause.Column{Name: column}, Value: value}
+}
+
 type BaseModel interface {
 	ToUnstructured() (*unstructured.Unstructured, error)
 	UniqueKey() string
 	TableName() string
+	GetUID() string
+	GetName() string
+	GetNamespace() string
+	GetResourceVersion() string
+	GetRaw() string
+	// GetColumns 配置的自定义列，列名 -> 值
+	GetColumns() map[string]any
 }
 
 // DynamicModel 基础模型，包含通用字段
@@ -21,17 +52,44 @@ type DynamicModel struct {
 	Name            string `gorm:"column:Name;size:255"`
 	NameSpace       string `gorm:"column:Namespace;size:255"`
 	Version         string `gorm:"column:Version"`
+	Group           string `gorm:"-"`
 	Resource        string `gorm:"-"`
-	UID             string `gorm:"column:UID;size:255;uniqueIndex:idx_uid"`
+	UID             string `gorm:"column:UID;size:255;uniqueIndex:,composite:uid"`
 	ResourceVersion string `gorm:"column:ResourceVersion"`
+	SyncGeneration  int64  `gorm:"column:SyncGeneration"`
 	Labels          string `gorm:"column:Labels;type:text"`
 	Annotations     string `gorm:"column:Annotations;type:text"`
 	Raw             string `gorm:"column:Raw;type:text"`
-	ClusterID       string `gorm:"column:ClusterID;size:255;uniqueIndex:idx_uid"`
+	ClusterID       string `gorm:"column:ClusterID;size:255;uniqueIndex:,composite:uid"`
+	DeletedReason   string `gorm:"column:DeletedReason;size:64"`
+	// Columns 配置的自定义列，由 Dao 与模型在同一条语句中写入
+	Columns map[string]any `gorm:"-" json:"-"`
 }
 
-// TableName 动态生成表名，格式: gvk_group_version_kind
+// maxTableNameLength 表名最大长度，为历史表后缀和索引名留出空间（MySQL 标识符最长 64 个字符）
+const maxTableNameLength = 40
+
+// groupTableReplacer 将 API 组中不能直接用于表名的字符替换为下划线
+var groupTableReplacer = strings.NewReplacer(".", "_", "-", "_")
+
+// TableName 动态生成表名，core 组为 Kind 形式，如 Pod；其他组加上组名前缀，如 apps_Deployment，
+// 避免不同组的同名资源（如 networking.istio.io/gateways 和 gateway.networking.k8s.io/gateways）写入同一张表
 func (dm *DynamicModel) TableName() string {
+	name := dm.legacyTableName()
+	if dm.Group == "" {
+		return name
+	}
+	name = groupTableReplacer.Replace(dm.Group) + "_" + name
+	if len(name) > maxTableNameLength {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(name))
+		name = fmt.Sprintf("%s_%08x", name[:maxTableNameLength-9], h.Sum32())
+	}
+	return name
+}
+
+// legacyTableName 旧版本不区分 API 组的表名，升级时从中迁移本组的记录
+func (dm *DynamicModel) legacyTableName() string {
 	name := strings.TrimSuffix(dm.Resource, "s")
 	if len(name) > 0 {
 		name = strings.ToUpper(name[:1]) + name[1:]
@@ -39,14 +97,50 @@ func (dm *DynamicModel) TableName() string {
 	return name
 }
 
+func (dm *DynamicModel) apiGroup() string {
+	return dm.Group
+}
+
 func (dm *DynamicModel) UniqueKey() string {
-	return fmt.Sprintf("%s-%s-%s", dm.Name, dm.ClusterID)
+	return fmt.Sprintf("%s-%s-%s", dm.NameSpace, dm.Name, dm.ClusterID)
 }
 
+func (dm *DynamicModel) GetUID() string {
+	return dm.UID
+}
+
+func (dm *DynamicModel) GetName() string {
+	return dm.Name
+}
+
+func (dm *DynamicModel) GetNamespace() string {
+	return dm.NameSpace
+}
+
+func (dm *DynamicModel) GetResourceVersion() string {
+	return dm.ResourceVersion
+}
+
+func (dm *DynamicModel) GetRaw() string {
+	return dm.Raw
+}
+
+func (dm *DynamicModel) GetColumns() map[string]any {
+	return dm.Columns
+}
+
+// ToUnstructured 解析 Raw，加密的 Raw 先解密
 func (dm *DynamicModel) ToUnstructured() (*unstructured.Unstructured, error) {
-	if dm.Raw == "" {
+	if dm.Raw != "" {
+		raw := dm.Raw
+		if isEncryptedRaw(raw) {
+			var err error
+			if raw, err = decryptRaw(raw); err != nil {
+				return nil, err
+			}
+		}
 		utd := &unstructured.Unstructured{}
-		err := json.Unmarshal([]byte(dm.Raw), &utd.Object)
+		err := utiljson.Unmarshal([]byte(raw), &utd.Object)
 		if err != nil {
 			return nil, err
 		}
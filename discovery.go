@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// defaultDiscoveryInterval 默认的重新发现周期
+const defaultDiscoveryInterval = 5 * time.Minute
+
+// discoveryResult 服务端发现结果
+type discoveryResult struct {
+	// served 白名单中可 list/watch 的资源 -> 是否 namespaced，每个 GroupResource 只有一个版本
+	served map[schema.GroupVersionResource]bool
+	// failed 发现失败的 GroupVersion，其下的控制器保持不变
+	failed map[schema.GroupVersion]struct{}
+}
+
+// versionCandidate 同一 GroupResource 的一个可用版本
+type versionCandidate struct {
+	version    string
+	namespaced bool
+	// exact 由白名单中的精确 GVR 指定
+	exact bool
+	// preferred 是否为服务端首选版本
+	preferred bool
+	// priority 版本在组内的优先级，越小越优先
+	priority int
+}
+
+// better 精确指定的版本优先，其次为服务端首选版本，最后按组内版本优先级
+func (c versionCandidate) better(other versionCandidate) bool {
+	if c.exact != other.exact {
+		return c.exact
+	}
+	if c.preferred != other.preferred {
+		return c.preferred
+	}
+	return c.priority < other.priority
+}
+
+// discover 执行服务端发现，部分 API 组发现失败时返回其余结果
+func (cm *ControllerManager) discover() (*discoveryResult, error) {
+	result := &discoveryResult{
+		served: make(map[schema.GroupVersionResource]bool),
+		failed: make(map[schema.GroupVersion]struct{}),
+	}
+	groups, resourceLists, err := cm.kubeClient.Discovery().ServerGroupsAndResources()
+	if err != nil {
+		groupErr, ok := err.(*discovery.ErrGroupDiscoveryFailed)
+		if !ok {
+			return nil, err
+		}
+		for gv, gvErr := range groupErr.Groups {
+			klog.Warningf("Discovery of %s failed: %v", gv, gvErr)
+			result.failed[gv] = struct{}{}
+		}
+	}
+
+	// 组内版本按优先级排列，第一个通常为首选版本
+	preferred := make(map[string]string)
+	priority := make(map[schema.GroupVersion]int)
+	for _, group := range groups {
+		preferred[group.Name] = group.PreferredVersion.Version
+		for i, version := range group.Versions {
+			priority[schema.GroupVersion{Group: group.Name, Version: version.Version}] = i
+		}
+	}
+
+	candidates := make(map[schema.GroupResource][]versionCandidate)
+	for _, resourceList := range resourceLists {
+		gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil {
+			continue
+		}
+
+		for _, resource := range resourceList.APIResources {
+			// 跳过子资源（包含/的resource名称）
+			if strings.Contains(resource.Name, "/") {
+				continue
+			}
+			gvr := gv.WithResource(resource.Name)
+			// 白名单及排除规则检查
+			if !cm.isWhitelisted(gvr, resource.Categories) {
+				klog.V(4).Infof("Skipping GVR not in whitelist: %s", gvr)
+				continue
+			}
+			// 检查是否支持list操作
+			if !stringSliceContains(resource.Verbs, "list") {
+				continue
+			}
+			// 检查是否支持watch操作
+			if !stringSliceContains(resource.Verbs, "watch") {
+				continue
+			}
+			gr := gvr.GroupResource()
+			candidates[gr] = append(candidates[gr], versionCandidate{
+				version:    gv.Version,
+				namespaced: resource.Namespaced,
+				exact:      cm.isExactWhitelisted(gvr),
+				preferred:  preferred[gv.Group] == gv.Version,
+				priority:   priority[gv],
+			})
+		}
+	}
+
+	// 同一资源的多个版本共用一张表，只同步一个版本
+	for gr, versions := range candidates {
+		best := versions[0]
+		for _, candidate := range versions[1:] {
+			if candidate.better(best) {
+				best = candidate
+			}
+		}
+		if len(versions) > 1 {
+			klog.V(2).Infof("Resource %s is served in %d versions, using %s", gr, len(versions), best.version)
+		}
+		result.served[gr.WithVersion(best.version)] = best.namespaced
+	}
+	return result, nil
+}
+
+// resolveGroupResource 通过服务端发现确定资源同步时使用的版本，与 Discover 的选择相同：
+// 白名单中精确指定的版本优先，其次为服务端首选版本；资源不在白名单中或服务端不提供时返回错误
+func (cm *ControllerManager) resolveGroupResource(gr schema.GroupResource) (schema.GroupVersionResource, error) {
+	if err := cm.initClients(); err != nil {
+		return schema.GroupVersionResource{}, err
+	}
+	result, err := cm.discover()
+	if err != nil {
+		return schema.GroupVersionResource{}, fmt.Errorf("discover %s: %w", gr, err)
+	}
+	for gvr := range result.served {
+		if gvr.GroupResource() == gr {
+			return gvr, nil
+		}
+	}
+	return schema.GroupVersionResource{}, fmt.Errorf("resource %s is not whitelisted or not served", gr)
+}
+
+// Discover 执行服务端发现，为新出现的白名单资源创建控制器，停止 API 已消失的控制器
+// 首选版本变化时停止旧版本的控制器并以新版本重新同步，记录的 Version 随之更新
+// 正在运行控制器时（选主成功后）新控制器会立即启动
+func (cm *ControllerManager) Discover(ctx context.Context) error {
+	result, err := cm.discover()
+	if err != nil {
+		return err
+	}
+	servedResources := make(map[schema.GroupResource]struct{}, len(result.served))
+	for gvr := range result.served {
+		servedResources[gvr.GroupResource()] = struct{}{}
+	}
+
+	// 先停止旧控制器，避免同一资源的两个版本同时写入
+	cm.mu.Lock()
+	for gvr, ctrl := range cm.controllers {
+		if _, ok := result.served[gvr]; ok {
+			continue
+		}
+		_, failed := result.failed[gvr.GroupVersion()]
+		_, replaced := servedResources[gvr.GroupResource()]
+		if failed && !replaced {
+			continue
+		}
+		if replaced {
+			klog.Infof("Version of %s changed, stopping controller", gvr)
+		} else {
+			klog.Infof("API for %s is no longer served, stopping controller", gvr)
+		}
+		if ctrl.stop != nil {
+			ctrl.stop()
+		}
+		if ctrl.registration != nil {
+			_ = ctrl.informer.Informer().RemoveEventHandler(ctrl.registration)
+		}
+		cm.releaseInformer(ctrl.informerKey)
+		delete(cm.controllers, gvr)
+	}
+	cm.mu.Unlock()
+
+	for gvr, namespaced := range result.served {
+		if cm.GetController(gvr) != nil {
+			continue
+		}
+		cm.createControllerForGVR(gvr, namespaced)
+		cm.mu.Lock()
+		if ctrl, ok := cm.controllers[gvr]; ok && cm.runCtx != nil && cm.runCtx.Err() == nil {
+			klog.Infof("API for %s is now served, starting controller", gvr)
+			cm.startController(ctrl)
+		}
+		cm.mu.Unlock()
+	}
+	return nil
+}
+
+// runDiscovery 监听 CRD 变化并周期性重新发现，直到 ctx 取消
+func (cm *ControllerManager) runDiscovery(ctx context.Context) {
+	trigger := make(chan struct{}, 1)
+	notify := func(interface{}) {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+
+	// CRD 创建后需要等待 Established，状态更新也会触发重新发现
+	// 白名单包含 CRD 且未配置过滤条件时与其控制器共用同一个 informer
+	key := informerKey{gvr: ApiextensionsV1CRD}
+	informer := cm.acquireInformer(key).Informer()
+	defer cm.releaseInformer(key)
+	registration, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    notify,
+		UpdateFunc: func(_, obj interface{}) { notify(obj) },
+		DeleteFunc: notify,
+	})
+	if err != nil {
+		klog.Errorf("Watch CRDs failed: %v", err)
+	} else {
+		defer func() { _ = informer.RemoveEventHandler(registration) }()
+	}
+	cm.runInformer(ctx, informer)
+
+	var tick <-chan time.Time
+	if cm.discoveryInterval > 0 {
+		ticker := time.NewTicker(cm.discoveryInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-trigger:
+		case <-tick:
+		}
+		if err := cm.Discover(ctx); err != nil {
+			klog.Errorf("Discovery failed: %v", err)
+		}
+	}
+}
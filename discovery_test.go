@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	discoveryfake "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+// testAPIResource 支持 list/watch 的资源
+func testAPIResource(name string, namespaced bool) metav1.APIResource {
+	return metav1.APIResource{Name: name, Namespaced: namespaced, Verbs: metav1.Verbs{"get", "list", "watch"}}
+}
+
+// setTestDiscovery 使用 fake 发现客户端，组内第一个版本为首选版本
+func setTestDiscovery(cm *ControllerManager, resources ...*metav1.APIResourceList) *discoveryfake.FakeDiscovery {
+	client := kubefake.NewSimpleClientset()
+	fake := client.Discovery().(*discoveryfake.FakeDiscovery)
+	fake.Resources = resources
+	cm.kubeClient = client
+	return fake
+}
+
+func TestResolveGroupResource(t *testing.T) {
+	resources := []*metav1.APIResourceList{
+		{GroupVersion: "v1", APIResources: []metav1.APIResource{testAPIResource("pods", true)}},
+		{GroupVersion: "apps/v1", APIResources: []metav1.APIResource{testAPIResource("deployments", true)}},
+		{GroupVersion: "apps/v1beta2", APIResources: []metav1.APIResource{testAPIResource("deployments", true)}},
+	}
+	deployments := schema.GroupResource{Group: "apps", Resource: "deployments"}
+	tests := []struct {
+		name      string
+		whitelist []string
+		gr        schema.GroupResource
+		want      schema.GroupVersionResource
+		wantErr   bool
+	}{
+		{name: "preferred version", whitelist: []string{"apps/deployments"}, gr: deployments, want: AppsV1Deployment},
+		{name: "exact version", whitelist: []string{"apps/v1beta2/deployments"}, gr: deployments, want: deployments.WithVersion("v1beta2")},
+		{name: "pattern", whitelist: []string{"*/*/*"}, gr: CoreV1Pod.GroupResource(), want: CoreV1Pod},
+		{name: "not whitelisted", whitelist: []string{"v1/pods"}, gr: deployments, wantErr: true},
+		{name: "not served", whitelist: []string{"batch/jobs"}, gr: BatchV1Job.GroupResource(), wantErr: true},
+	}
+	for _, tt := range tests {
+		cm := newTestManager()
+		if err := cm.ApplyConfig(&Config{Whitelist: tt.whitelist}); err != nil {
+			t.Fatal(err)
+		}
+		setTestDiscovery(cm, resources...)
+		got, err := cm.resolveGroupResource(tt.gr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+// newTestCRD 构造 CRD 对象，仅用于触发重新发现
+func newTestCRD(name string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiextensions.k8s.io/v1",
+		"kind":       "CustomResourceDefinition",
+		"metadata":   map[string]interface{}{"name": name},
+	}}
+}
+
+func TestDiscoverStartsAndStopsControllers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	widgetsV1 := schema.GroupVersionResource{Group: "demo.example.com", Version: "v1", Resource: "widgets"}
+	widgetsV2 := widgetsV1.GroupResource().WithVersion("v2")
+	cm := newTestManager()
+	cm.dynamicClient = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			CoreV1Pod:          "PodList",
+			CoreV1Namespace:    "NamespaceList",
+			ApiextensionsV1CRD: "CustomResourceDefinitionList",
+			widgetsV1:          "WidgetList",
+			widgetsV2:          "WidgetList",
+		})
+	if err := cm.ApplyConfig(&Config{Whitelist: []string{"v1/pods", "*.example.com/*/*"}}); err != nil {
+		t.Fatal(err)
+	}
+	cm.UseDB(newTestDB(t))
+	cm.discoveryInterval = 0
+	pods := &metav1.APIResourceList{GroupVersion: "v1", APIResources: []metav1.APIResource{testAPIResource("pods", true)}}
+	widgets := func(version string) *metav1.APIResourceList {
+		return &metav1.APIResourceList{GroupVersion: "demo.example.com/" + version, APIResources: []metav1.APIResource{testAPIResource("widgets", true)}}
+	}
+	fake := setTestDiscovery(cm, pods)
+
+	cm.startControllers(ctx)
+	if err := cm.Discover(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if cm.GetController(CoreV1Pod) == nil || cm.GetController(widgetsV1) != nil {
+		t.Fatal("expected only the pod controller before the CRD is served")
+	}
+	go cm.runDiscovery(ctx)
+
+	// running 等待控制器集合满足条件且全部已启动
+	running := func(step string, cond func(controllers map[schema.GroupVersionResource]*Controller) bool) {
+		t.Helper()
+		err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+			cm.mu.Lock()
+			defer cm.mu.Unlock()
+			for _, ctrl := range cm.controllers {
+				if ctrl.stop == nil {
+					return false, nil
+				}
+			}
+			return cond(cm.controllers), nil
+		})
+		if err != nil {
+			t.Fatalf("%s: controllers not updated", step)
+		}
+	}
+	crds := cm.dynamicClient.Resource(ApiextensionsV1CRD)
+
+	// CRD 创建后新资源的控制器随即启动
+	fake.Resources = []*metav1.APIResourceList{pods, widgets("v1")}
+	crd, err := crds.Create(ctx, newTestCRD("widgets.demo.example.com"), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	running("created", func(controllers map[schema.GroupVersionResource]*Controller) bool {
+		return len(controllers) == 2 && controllers[widgetsV1] != nil
+	})
+	old := cm.GetController(widgetsV1)
+
+	// 首选版本变化时旧版本的控制器停止，新版本的控制器启动
+	fake.Resources = []*metav1.APIResourceList{pods, widgets("v2"), widgets("v1")}
+	crd.SetLabels(map[string]string{"version": "v2"})
+	if _, err = crds.Update(ctx, crd, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	running("version changed", func(controllers map[schema.GroupVersionResource]*Controller) bool {
+		return len(controllers) == 2 && controllers[widgetsV2] != nil
+	})
+	if err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		return old.queue.ShuttingDown(), nil
+	}); err != nil {
+		t.Fatal("controller of the replaced version still running")
+	}
+
+	// CRD 删除后控制器停止，内置资源的控制器不受影响
+	fake.Resources = []*metav1.APIResourceList{pods}
+	if err = crds.Delete(ctx, crd.GetName(), metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	running("deleted", func(controllers map[schema.GroupVersionResource]*Controller) bool {
+		return len(controllers) == 1 && controllers[CoreV1Pod] != nil
+	})
+
+	cancel()
+	cm.waitControllers()
+}
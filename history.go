@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// historyTableSuffix 历史表名后缀，如 Pod 的历史表为 PodHistory
+const historyTableSuffix = "History"
+
+// Revision 对象的一个历史版本，只追加不修改
+type Revision struct {
+	ID              uint      `gorm:"primarykey"`
+	ClusterID       string    `gorm:"column:ClusterID;size:255;index:,composite:object"`
+	UID             string    `gorm:"column:UID;size:255;index:,composite:object"`
+	Name            string    `gorm:"column:Name;size:255"`
+	NameSpace       string    `gorm:"column:Namespace;size:255"`
+	ResourceVersion string    `gorm:"column:ResourceVersion"`
+	Action          string    `gorm:"column:Action;size:32"`
+	Timestamp       time.Time `gorm:"column:Timestamp;index"`
+	Raw             string    `gorm:"column:Raw;type:text"`
+	// Diff 相对上一个版本的 JSON Merge Patch（RFC 7386），第一个版本为空
+	Diff string `gorm:"column:Diff;type:text"`
+}
+
+// SnapshotDao 支持按时间点重建对象集合的存储
+type SnapshotDao interface {
+	// Snapshot 返回 at 时刻存在的对象，namespace 为空时返回所有命名空间
+	Snapshot(ctx context.Context, namespace string, at time.Time) ([]BaseModel, error)
+}
+
+// ToModel 转换为 DynamicModel，便于复用 ToUnstructured
+func (r *Revision) ToModel() *DynamicModel {
+	return &DynamicModel{
+		ClusterID:       r.ClusterID,
+		Name:            r.Name,
+		NameSpace:       r.NameSpace,
+		UID:             r.UID,
+		ResourceVersion: r.ResourceVersion,
+		Raw:             r.Raw,
+	}
+}
+
+// NewHistoryDao 包装 Dao，在数据变化时向历史表追加版本
+func NewHistoryDao(clusterID string, db *gorm.DB, inner Dao) Dao {
+	return &historyDao{
+		Dao:       inner,
+		clusterID: clusterID,
+		db:        db,
+	}
+}
+
+type historyDao struct {
+	Dao
+	clusterID string
+	db        *gorm.DB
+}
+
+func (h *historyDao) historyTable(ctx context.Context) string {
+	return h.Dao.TableName(ctx) + historyTableSuffix
+}
+
+func (h *historyDao) AutoMigrate(ctx context.Context) error {
+	if err := h.Dao.AutoMigrate(ctx); err != nil {
+		return err
+	}
+	if err := conn(ctx, h.db).Table(h.historyTable(ctx)).AutoMigrate(&Revision{}); err != nil {
+		return err
+	}
+	legacy, group := legacyTable(h.Dao.GetModel(ctx, nil), historyTableSuffix)
+	return migrateLegacyRows(conn(ctx, h.db), legacy, h.historyTable(ctx), group, &Revision{})
+}
+
+// Create 写入记录和追加版本在同一个事务中，任一失败时都回滚，由队列重试；Save、Upsert、Delete 相同
+func (h *historyDao) Create(ctx context.Context, u *unstructured.Unstructured) error {
+	return transaction(ctx, h.db, func(ctx context.Context) error {
+		if err := h.Dao.Create(ctx, u); err != nil {
+			return err
+		}
+		return h.record(ctx, ActionAdd, h.Dao.GetModel(ctx, u))
+	})
+}
+
+func (h *historyDao) Save(ctx context.Context, u *unstructured.Unstructured) (bool, error) {
+	var written bool
+	err := transaction(ctx, h.db, func(ctx context.Context) error {
+		var err error
+		// 过期写入被拒绝时不记录版本
+		written, err = h.Dao.Save(ctx, u)
+		if err != nil || !written {
+			return err
+		}
+		return h.record(ctx, ActionUpdate, h.Dao.GetModel(ctx, u))
+	})
+	return written, err
+}
+
+func (h *historyDao) Upsert(ctx context.Context, u *unstructured.Unstructured) (bool, error) {
+	var written bool
+	err := transaction(ctx, h.db, func(ctx context.Context) error {
+		var err error
+		written, err = h.Dao.Upsert(ctx, u)
+		if err != nil || !written {
+			return err
+		}
+		model := h.Dao.GetModel(ctx, u)
+		last, err := h.lastRevision(ctx, model.GetUID())
+		if err != nil {
+			return err
+		}
+		action := ActionUpdate
+		if last == nil || last.Action == ActionDelete {
+			action = ActionAdd
+		}
+		return h.record(ctx, action, model)
+	})
+	if err != nil {
+		return false, err
+	}
+	return written, nil
+}
+
+func (h *historyDao) Delete(ctx context.Context, namespace string, name string, info DeleteInfo) error {
+	return transaction(ctx, h.db, func(ctx context.Context) error {
+		return h.delete(ctx, namespace, name, info)
+	})
+}
+
+func (h *historyDao) delete(ctx context.Context, namespace string, name string, info DeleteInfo) error {
+	// 优先使用删除事件中的最终状态，否则删除前从存储读取
+	var model BaseModel
+	if info.Final != nil {
+		model = h.Dao.GetModel(ctx, info.Final)
+	} else {
+		stored, err := h.Dao.First(ctx, namespace, name)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil {
+			model = stored
+		}
+	}
+	if err := h.Dao.Delete(ctx, namespace, name, info); err != nil {
+		return err
+	}
+	if model == nil {
+		return nil
+	}
+	return h.record(ctx, ActionDelete, model)
+}
+
+func (h *historyDao) DeleteByUID(ctx context.Context, uid string, info DeleteInfo) error {
+	return transaction(ctx, h.db, func(ctx context.Context) error {
+		return h.deleteByUID(ctx, uid, info)
+	})
+}
+
+func (h *historyDao) deleteByUID(ctx context.Context, uid string, info DeleteInfo) error {
+	last, err := h.lastRevision(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if err = h.Dao.DeleteByUID(ctx, uid, info); err != nil {
+		return err
+	}
+	if last == nil || last.Action == ActionDelete {
+		return nil
+	}
+	deleted := *last
+	deleted.ID = 0
+	deleted.Action = ActionDelete
+	deleted.Timestamp = time.Now()
+	deleted.Diff = ""
+	return conn(ctx, h.db).Table(h.historyTable(ctx)).Create(&deleted).Error
+}
+
+// PurgeAll 同时删除当前集群的历史版本
+func (h *historyDao) PurgeAll(ctx context.Context) (int64, error) {
+	n, err := h.Dao.PurgeAll(ctx)
+	if err != nil {
+		return n, err
+	}
+	db := conn(ctx, h.db)
+	if !db.Migrator().HasTable(h.historyTable(ctx)) {
+		return n, nil
+	}
+	result := db.Table(h.historyTable(ctx)).
+		Where(columnEq(columnClusterID, h.clusterID)).
+		Delete(&Revision{})
+	return n + result.RowsAffected, result.Error
+}
+
+// Snapshot 取每个对象在 at 时刻之前的最后一个版本，排除已删除的对象
+func (h *historyDao) Snapshot(ctx context.Context, namespace string, at time.Time) ([]BaseModel, error) {
+	latest := h.db.Table(h.historyTable(ctx)).
+		Select("MAX(id)").
+		Where(columnEq(columnClusterID, h.clusterID)).
+		Where(clause.Lte{Column: clause.Column{Name: columnTimestamp}, Value: at}).
+		Group(columnUID)
+	if namespace != "" {
+		latest = latest.Where(columnEq(columnNamespace, namespace))
+	}
+
+	var revisions []Revision
+	err := conn(ctx, h.db).Table(h.historyTable(ctx)).
+		Where("id IN (?)", latest).
+		Where(clause.Neq{Column: clause.Column{Name: columnAction}, Value: ActionDelete}).
+		Order(clause.OrderByColumn{Column: clause.Column{Name: columnNamespace}}).
+		Order(clause.OrderByColumn{Column: clause.Column{Name: columnName}}).
+		Find(&revisions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]BaseModel, 0, len(revisions))
+	for i := range revisions {
+		models = append(models, revisions[i].ToModel())
+	}
+	return models, nil
+}
+
+// lastRevision 返回对象最新的版本，不存在时返回 nil
+func (h *historyDao) lastRevision(ctx context.Context, uid string) (*Revision, error) {
+	var revisions []Revision
+	err := conn(ctx, h.db).Table(h.historyTable(ctx)).
+		Where(columnEq(columnClusterID, h.clusterID)).
+		Where(columnEq(columnUID, uid)).
+		Order("id DESC").Limit(1).
+		Find(&revisions).Error
+	if err != nil || len(revisions) == 0 {
+		return nil, err
+	}
+	return &revisions[0], nil
+}
+
+// record 追加一个版本，ResourceVersion 与上一版本相同（如重试）时跳过
+func (h *historyDao) record(ctx context.Context, action string, model BaseModel) error {
+	if model == nil || model.GetUID() == "" {
+		return nil
+	}
+	last, err := h.lastRevision(ctx, model.GetUID())
+	if err != nil {
+		return err
+	}
+	if last != nil && last.Action == action && last.ResourceVersion == model.GetResourceVersion() {
+		return nil
+	}
+
+	revision := &Revision{
+		ClusterID:       h.clusterID,
+		UID:             model.GetUID(),
+		Name:            model.GetName(),
+		NameSpace:       model.GetNamespace(),
+		ResourceVersion: model.GetResourceVersion(),
+		Action:          action,
+		Timestamp:       time.Now(),
+		Raw:             model.GetRaw(),
+	}
+	// 加密的 Raw 无法比较，也不应以明文保存差异
+	if last != nil && last.Raw != "" && revision.Raw != "" && !isEncryptedRaw(last.Raw) && !isEncryptedRaw(revision.Raw) {
+		diff, err := jsonpatch.CreateMergePatch([]byte(last.Raw), []byte(revision.Raw))
+		if err != nil {
+			return err
+		}
+		revision.Diff = string(diff)
+	}
+	return conn(ctx, h.db).Table(h.historyTable(ctx)).Create(revision).Error
+}
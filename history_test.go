@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// newTestHistoryDao 创建并建表带历史的 Pod Dao
+func newTestHistoryDao(t *testing.T, db *gorm.DB) *historyDao {
+	t.Helper()
+	h := NewHistoryDao("test", db, NewDao("test", db, CoreV1Pod, true, nil)).(*historyDao)
+	if err := h.AutoMigrate(context.Background()); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+	return h
+}
+
+// revisions 返回对象的所有版本，按写入顺序排列
+func revisions(t *testing.T, h *historyDao, uid string) []Revision {
+	t.Helper()
+	var result []Revision
+	err := h.db.Table(h.historyTable(context.Background())).
+		Where(columnEq(columnUID, uid)).Order("id").Find(&result).Error
+	if err != nil {
+		t.Fatalf("list revisions: %v", err)
+	}
+	return result
+}
+
+func TestHistoryDaoRecordsRevisions(t *testing.T) {
+	ctx := context.Background()
+	h := newTestHistoryDao(t, newTestDB(t))
+
+	if _, err := h.Upsert(ctx, newTestPod("web", "uid-1", "10")); err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+	if _, err := h.Upsert(ctx, newTestPod("web", "uid-1", "11")); err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+	if err := h.Delete(ctx, "default", "web", DeleteInfo{Reason: DeleteReasonWatch}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	got := revisions(t, h, "uid-1")
+	want := []string{ActionAdd, ActionUpdate, ActionDelete}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d revisions, got %d", len(want), len(got))
+	}
+	for i, action := range want {
+		if got[i].Action != action {
+			t.Errorf("revision %d: expected %s, got %s", i, action, got[i].Action)
+		}
+	}
+}
+
+func TestHistoryDaoRollsBackOnRecordFailure(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	h := newTestHistoryDao(t, db)
+
+	// 历史表不存在时追加版本失败，记录本身也不应写入
+	if err := db.Migrator().DropTable(h.historyTable(ctx)); err != nil {
+		t.Fatalf("drop history table: %v", err)
+	}
+	if _, err := h.Upsert(ctx, newTestPod("web", "uid-1", "10")); err == nil {
+		t.Fatal("expected upsert to fail without history table")
+	}
+	if _, err := h.First(ctx, "default", "web"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expected row write to be rolled back, got %v", err)
+	}
+}
+
+func TestHistoryDaoSkipsStaleSave(t *testing.T) {
+	ctx := context.Background()
+	h := newTestHistoryDao(t, newTestDB(t))
+
+	if _, err := h.Upsert(ctx, newTestPod("web", "uid-1", "12")); err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+	written, err := h.Save(ctx, newTestPod("web", "uid-1", "11"))
+	if err != nil || written {
+		t.Fatalf("expected stale save to be rejected: written=%v err=%v", written, err)
+	}
+	if got := revisions(t, h, "uid-1"); len(got) != 1 || got[0].Action != ActionAdd {
+		t.Fatalf("stale save recorded a revision: %+v", got)
+	}
+
+	if _, err = h.Save(ctx, newTestPod("web", "uid-1", "13")); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if got := revisions(t, h, "uid-1"); len(got) != 2 || got[1].Action != ActionUpdate {
+		t.Fatalf("expected an update revision, got %+v", got)
+	}
+}
+
+func TestHistoryDaoSnapshot(t *testing.T) {
+	ctx := context.Background()
+	h := newTestHistoryDao(t, newTestDB(t))
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hour int) time.Time {
+		return base.Add(time.Duration(hour) * time.Hour)
+	}
+	// stamp 将对象最新版本的时间设为 hour，得到确定的时间线
+	stamp := func(uid string, hour int) {
+		t.Helper()
+		last, err := h.lastRevision(ctx, uid)
+		if err != nil || last == nil {
+			t.Fatalf("no revision for %s: %v", uid, err)
+		}
+		err = h.db.Table(h.historyTable(ctx)).Where("id = ?", last.ID).
+			Update(columnTimestamp, at(hour)).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	podOn := func(name, uid, resourceVersion, nodeName string) *unstructured.Unstructured {
+		pod := newTestPod(name, uid, resourceVersion)
+		_ = unstructured.SetNestedField(pod.Object, nodeName, "spec", "nodeName")
+		return pod
+	}
+
+	job := podOn("job", "uid-job", "1", "node-1")
+	job.SetNamespace("team")
+	steps := []struct {
+		hour  int
+		uid   string
+		write func() error
+	}{
+		{hour: 1, uid: "uid-web", write: func() error { _, err := h.Upsert(ctx, podOn("web", "uid-web", "1", "node-1")); return err }},
+		{hour: 1, uid: "uid-api", write: func() error { _, err := h.Upsert(ctx, podOn("api", "uid-api", "1", "node-1")); return err }},
+		{hour: 2, uid: "uid-web", write: func() error { _, err := h.Upsert(ctx, podOn("web", "uid-web", "2", "node-2")); return err }},
+		{hour: 2, uid: "uid-job", write: func() error { _, err := h.Upsert(ctx, job); return err }},
+		{hour: 3, uid: "uid-api", write: func() error {
+			return h.Delete(ctx, "default", "api", DeleteInfo{Reason: DeleteReasonWatch})
+		}},
+	}
+	for _, step := range steps {
+		if err := step.write(); err != nil {
+			t.Fatal(err)
+		}
+		stamp(step.uid, step.hour)
+	}
+
+	tests := []struct {
+		name      string
+		hour      int
+		namespace string
+		// want namespace/name -> spec.nodeName
+		want map[string]string
+	}{
+		{name: "before first write", hour: 0, want: map[string]string{}},
+		{name: "created", hour: 1, want: map[string]string{"default/api": "node-1", "default/web": "node-1"}},
+		{name: "updated", hour: 2, want: map[string]string{"default/api": "node-1", "default/web": "node-2", "team/job": "node-1"}},
+		{name: "deleted", hour: 3, want: map[string]string{"default/web": "node-2", "team/job": "node-1"}},
+		{name: "namespace", hour: 3, namespace: "team", want: map[string]string{"team/job": "node-1"}},
+	}
+	for _, tt := range tests {
+		models, err := h.Snapshot(ctx, tt.namespace, at(tt.hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]string, len(models))
+		for _, model := range models {
+			obj, err := model.ToUnstructured()
+			if err != nil {
+				t.Fatal(err)
+			}
+			nodeName, _, _ := unstructured.NestedString(obj.Object, "spec", "nodeName")
+			got[objectKey(obj.GetNamespace(), obj.GetName())] = nodeName
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
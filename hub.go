@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	apiserror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// hubClusterIDLabel Secret 上记录集群 ID 的标签，没有时使用 Secret 名称
+	hubClusterIDLabel = "kubesync.io/cluster-id"
+	// defaultHubKubeconfigKey Secret 中存放 kubeconfig 的键
+	defaultHubKubeconfigKey = "kubeconfig"
+)
+
+// HubConfig hub 模式：在 hub 集群中创建带标签的 Secret 注册集群，Secret 中存放目标集群的 kubeconfig
+type HubConfig struct {
+	// Kubeconfig/Context/InCluster 连接 hub 集群的方式，均为空且运行在 Pod 中时使用 ServiceAccount
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	Context    string `json:"context,omitempty"`
+	InCluster  bool   `json:"inCluster,omitempty"`
+	// Namespace Secret 所在命名空间，为空时监听所有命名空间
+	Namespace string `json:"namespace,omitempty"`
+	// LabelSelector 筛选集群 Secret，默认为 kubesync.io/cluster-id
+	LabelSelector string `json:"labelSelector,omitempty"`
+	// KubeconfigKey Secret 中 kubeconfig 的键，默认为 kubeconfig
+	KubeconfigKey string `json:"kubeconfigKey,omitempty"`
+	// PurgeOnRemove 删除 Secret 时同时删除该集群在数据库中的所有记录
+	PurgeOnRemove bool `json:"purgeOnRemove,omitempty"`
+	// ResyncPeriod Secret informer 的重新同步周期
+	ResyncPeriod *metav1.Duration `json:"resyncPeriod,omitempty"`
+}
+
+// Validate 校验 hub 配置
+func (c *HubConfig) Validate() error {
+	if c.InCluster && (c.Kubeconfig != "" || c.Context != "") {
+		return fmt.Errorf("hub: kubeconfig/context and inCluster are mutually exclusive")
+	}
+	if _, err := labels.Parse(c.labelSelector()); err != nil {
+		return fmt.Errorf("hub: invalid labelSelector: %w", err)
+	}
+	if c.ResyncPeriod != nil && c.ResyncPeriod.Duration < 0 {
+		return fmt.Errorf("hub: resyncPeriod must not be negative")
+	}
+	return nil
+}
+
+func (c *HubConfig) labelSelector() string {
+	if c.LabelSelector == "" {
+		return hubClusterIDLabel
+	}
+	return c.LabelSelector
+}
+
+func (c *HubConfig) kubeconfigKey() string {
+	if c.KubeconfigKey == "" {
+		return defaultHubKubeconfigKey
+	}
+	return c.KubeconfigKey
+}
+
+// HubWatcher 监听 hub 集群中的集群 Secret，在 ClusterRegistry 中启动和停止对应集群
+type HubWatcher struct {
+	cfg      HubConfig
+	registry *ClusterRegistry
+	factory  dynamicinformer.DynamicSharedInformerFactory
+	informer informers.GenericInformer
+	queue    workqueue.TypedRateLimitingInterface[string]
+
+	mu sync.Mutex
+	// clusters Secret namespace/name -> 已注册的集群
+	clusters map[string]hubCluster
+}
+
+type hubCluster struct {
+	id       string
+	checksum [sha256.Size]byte
+}
+
+// NewHubWatcher 连接 hub 集群并创建 Secret informer
+func NewHubWatcher(cfg HubConfig, registry *ClusterRegistry) (*HubWatcher, error) {
+	inCluster := cfg.InCluster || (cfg.Kubeconfig == "" && cfg.Context == "" && runningInCluster())
+	restConfig, err := loadRestConfig(cfg.Kubeconfig, cfg.Context, inCluster)
+	if err != nil {
+		return nil, fmt.Errorf("hub: %w", err)
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("hub: %w", err)
+	}
+	return newHubWatcher(cfg, registry, client)
+}
+
+// newHubWatcher 使用 hub 集群的 client 创建 Secret informer
+func newHubWatcher(cfg HubConfig, registry *ClusterRegistry, client dynamic.Interface) (*HubWatcher, error) {
+	namespace := cfg.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceAll
+	}
+	selector := cfg.labelSelector()
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(
+		client,
+		durationOrDefault(cfg.ResyncPeriod, defaultResyncPeriod),
+		namespace,
+		func(options *metav1.ListOptions) {
+			options.LabelSelector = selector
+		},
+	)
+	w := &HubWatcher{
+		cfg:      cfg,
+		registry: registry,
+		factory:  factory,
+		informer: factory.ForResource(CoreV1Secret),
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "hub"},
+		),
+		clusters: make(map[string]hubCluster),
+	}
+	_, err := w.informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.enqueue,
+		UpdateFunc: func(_, obj interface{}) { w.enqueue(obj) },
+		DeleteFunc: w.enqueue,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *HubWatcher) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		klog.Errorf("Hub: %v", err)
+		return
+	}
+	w.queue.Add(key)
+}
+
+// Run 运行到 ctx 取消，返回时已通过该 watcher 注册的集群仍在 registry 中，由调用方 Shutdown
+func (w *HubWatcher) Run(ctx context.Context) {
+	defer w.queue.ShutDown()
+	w.factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), w.informer.Informer().HasSynced) {
+		klog.Error("Hub: failed to sync cluster secrets")
+		return
+	}
+	klog.Infof("Hub: watching cluster secrets with selector %q", w.cfg.labelSelector())
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		for w.processNextItem(ctx) {
+		}
+	}, time.Second)
+	<-ctx.Done()
+}
+
+func (w *HubWatcher) processNextItem(ctx context.Context) bool {
+	key, quit := w.queue.Get()
+	if quit {
+		return false
+	}
+	defer w.queue.Done(key)
+	if err := w.sync(ctx, key); err != nil {
+		klog.Errorf("Hub: sync secret %s failed: %v", key, err)
+		w.queue.AddRateLimited(key)
+		return true
+	}
+	w.queue.Forget(key)
+	return true
+}
+
+// sync 根据 Secret 当前状态注册、重新注册或移除集群
+func (w *HubWatcher) sync(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil
+	}
+	obj, err := w.informer.Lister().ByNamespace(namespace).Get(name)
+	if apiserror.IsNotFound(err) {
+		return w.remove(ctx, key)
+	}
+	if err != nil {
+		return err
+	}
+
+	secret := obj.(*unstructured.Unstructured)
+	id := secret.GetLabels()[hubClusterIDLabel]
+	if id == "" {
+		id = secret.GetName()
+	}
+	kubeconfig, err := secretData(secret, w.cfg.kubeconfigKey())
+	if err != nil {
+		return err
+	}
+	current := hubCluster{id: id, checksum: sha256.Sum256(kubeconfig)}
+
+	w.mu.Lock()
+	previous, registered := w.clusters[key]
+	w.mu.Unlock()
+	if registered && previous == current {
+		return nil
+	}
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return fmt.Errorf("parse kubeconfig: %w", err)
+	}
+	if registered {
+		// 集群 ID 或 kubeconfig 变化，重新注册，不删除数据
+		w.registry.Remove(previous.id)
+		w.mu.Lock()
+		delete(w.clusters, key)
+		w.mu.Unlock()
+	}
+	if err = w.registry.Add(ctx, ClusterConfig{ID: id}, restConfig); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.clusters[key] = current
+	w.mu.Unlock()
+	klog.Infof("Hub: cluster %s registered from secret %s", id, key)
+	return nil
+}
+
+// remove 停止 Secret 对应的集群，PurgeOnRemove 时删除该集群的所有记录
+func (w *HubWatcher) remove(ctx context.Context, key string) error {
+	w.mu.Lock()
+	cluster, ok := w.clusters[key]
+	w.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	w.registry.Remove(cluster.id)
+	if w.cfg.PurgeOnRemove {
+		n, err := w.registry.Purge(ctx, cluster.id)
+		if err != nil {
+			return fmt.Errorf("purge cluster %s: %w", cluster.id, err)
+		}
+		klog.Infof("Hub: purged %d rows of cluster %s", n, cluster.id)
+	}
+	w.mu.Lock()
+	delete(w.clusters, key)
+	w.mu.Unlock()
+	klog.Infof("Hub: cluster %s unregistered, secret %s removed", cluster.id, key)
+	return nil
+}
+
+// secretData 读取并解码 Secret 的 data 字段
+func secretData(secret *unstructured.Unstructured, key string) ([]byte, error) {
+	encoded, found, err := unstructured.NestedString(secret.Object, "data", key)
+	if err != nil {
+		return nil, err
+	}
+	if !found || encoded == "" {
+		return nil, fmt.Errorf("key %q not found in secret", key)
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
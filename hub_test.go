@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+// testKubeconfig 指向不可达 API Server 的 kubeconfig
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: member
+  cluster:
+    server: https://127.0.0.1:1
+contexts:
+- name: member
+  context:
+    cluster: member
+current-context: member
+`
+
+// newTestHubSecret 构造注册集群的 Secret
+func newTestHubSecret(clusterID string) *unstructured.Unstructured {
+	secret := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]interface{}{"name": "member", "namespace": "hub"},
+		"data":       map[string]interface{}{defaultHubKubeconfigKey: base64.StdEncoding.EncodeToString([]byte(testKubeconfig))},
+	}}
+	secret.SetLabels(map[string]string{hubClusterIDLabel: clusterID})
+	return secret
+}
+
+func TestHubWatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := newTestDB(t)
+	newTestDao(t, db)
+	daos := map[string]Dao{}
+	for _, id := range []string{"member", "renamed"} {
+		d := NewDao(id, db, CoreV1Pod, true, nil)
+		if _, err := d.Upsert(ctx, newTestPod("web", "uid-"+id, "1")); err != nil {
+			t.Fatal(err)
+		}
+		daos[id] = d
+	}
+	r := NewClusterRegistry(&Config{Whitelist: []string{"v1/pods"}}, db)
+	defer r.Shutdown()
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{CoreV1Secret: "SecretList"})
+	w, err := newHubWatcher(HubConfig{Namespace: "hub", PurgeOnRemove: true}, r, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go w.Run(ctx)
+
+	waitClusters := func(want ...string) {
+		t.Helper()
+		err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+			got := r.Clusters()
+			return len(got) == len(want) && (len(want) == 0 || reflect.DeepEqual(got, want)), nil
+		})
+		if err != nil {
+			t.Fatalf("expected clusters %v, got %v", want, r.Clusters())
+		}
+	}
+	secrets := client.Resource(CoreV1Secret).Namespace("hub")
+	if _, err = secrets.Create(ctx, newTestHubSecret("member"), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitClusters("member")
+
+	// 集群 ID 变化时重新注册，不删除原集群的数据
+	if _, err = secrets.Update(ctx, newTestHubSecret("renamed"), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitClusters("renamed")
+	if _, err = daos["member"].First(ctx, "default", "web"); err != nil {
+		t.Fatalf("rows of the re-registered cluster were deleted: %v", err)
+	}
+
+	// 删除 Secret 时移除集群并删除其数据
+	if err = secrets.Delete(ctx, "member", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitClusters()
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+		_, err := daos["renamed"].First(ctx, "default", "web")
+		return errors.Is(err, gorm.ErrRecordNotFound), nil
+	})
+	if err != nil {
+		t.Fatal("rows of the removed cluster not purged")
+	}
+	if _, err = daos["member"].First(ctx, "default", "web"); err != nil {
+		t.Fatalf("rows of another cluster were purged: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// informerScope informer 的 list/watch 范围，范围和 GVR 相同时共用一个 informer，
+// 同一 GVR 只建立一个 watch，依赖查询（Controller.GetObj）和控制器自身使用同一份缓存
+type informerScope struct {
+	namespace     string
+	labelSelector string
+	fieldSelector string
+}
+
+// informerKey 共享 informer 的键
+type informerKey struct {
+	scope informerScope
+	gvr   schema.GroupVersionResource
+}
+
+// sharedInformer 被多个使用方共享的 informer，refs 为使用方数量，归零时停止
+type sharedInformer struct {
+	informer informers.GenericInformer
+	refs     int
+	started  bool
+	// stop 移除 informer 时关闭
+	stop chan struct{}
+}
+
+// acquireInformer 返回范围内 GVR 的共享 informer 并增加引用，不存在时创建
+// resync 周期为默认值，GVR 的 resync 周期通过 AddEventHandlerWithResyncPeriod 设置
+func (cm *ControllerManager) acquireInformer(key informerKey) informers.GenericInformer {
+	cm.informersMu.Lock()
+	defer cm.informersMu.Unlock()
+	if shared, ok := cm.sharedInformers[key]; ok {
+		shared.refs++
+		return shared.informer
+	}
+	informer := dynamicinformer.NewFilteredDynamicInformer(
+		cm.dynamicClient,
+		key.gvr,
+		key.scope.namespace,
+		defaultResyncPeriod,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+		func(options *metav1.ListOptions) {
+			options.LabelSelector = key.scope.labelSelector
+			options.FieldSelector = key.scope.fieldSelector
+		},
+	)
+	cm.sharedInformers[key] = &sharedInformer{informer: informer, refs: 1, stop: make(chan struct{})}
+	return informer
+}
+
+// releaseInformer 减少引用，没有使用方时停止并移除 informer，之后再次获取时重新创建
+// 只影响该 GVR 的 informer，同一范围内的其他 informer 继续运行
+func (cm *ControllerManager) releaseInformer(key informerKey) {
+	cm.informersMu.Lock()
+	defer cm.informersMu.Unlock()
+	shared, ok := cm.sharedInformers[key]
+	if !ok {
+		return
+	}
+	shared.refs--
+	if shared.refs > 0 {
+		return
+	}
+	delete(cm.sharedInformers, key)
+	close(shared.stop)
+}
+
+// runInformer 运行 informer 直到 ctx 取消或 informer 被移除，已运行的 informer 直接返回
+// ctx 为管理器的运行 context，informer 的生命周期不随启动它的控制器结束
+func (cm *ControllerManager) runInformer(ctx context.Context, informer cache.SharedIndexInformer) {
+	cm.informersMu.Lock()
+	defer cm.informersMu.Unlock()
+	shared := cm.lookupInformer(informer)
+	if shared == nil || shared.started {
+		return
+	}
+	shared.started = true
+	stopCh := make(chan struct{})
+	go func() {
+		defer close(stopCh)
+		select {
+		case <-ctx.Done():
+		case <-shared.stop:
+		}
+	}()
+	go informer.Run(stopCh)
+}
+
+// lookupInformer 返回 informer 对应的共享 informer，已移除时返回空，需要持有 cm.informersMu
+func (cm *ControllerManager) lookupInformer(informer cache.SharedIndexInformer) *sharedInformer {
+	for _, shared := range cm.sharedInformers {
+		if shared.informer.Informer() == informer {
+			return shared
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newTestManager 创建使用 fake dynamic client 的 ControllerManager
+func newTestManager() *ControllerManager {
+	cm := NewControllerManager("test", nil)
+	cm.dynamicClient = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			CoreV1Pod:       "PodList",
+			CoreV1Namespace: "NamespaceList",
+		})
+	return cm
+}
+
+func TestSharedInformerLifecycle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cm := newTestManager()
+
+	podKey := informerKey{gvr: CoreV1Pod}
+	nsKey := informerKey{gvr: CoreV1Namespace}
+	pods := cm.acquireInformer(podKey)
+	if cm.acquireInformer(podKey) != pods {
+		t.Fatal("expected the same informer for the same scope and GVR")
+	}
+	namespaces := cm.acquireInformer(nsKey)
+
+	cm.runInformer(ctx, pods.Informer())
+	cm.runInformer(ctx, namespaces.Informer())
+	if !cache.WaitForCacheSync(ctx.Done(), pods.Informer().HasSynced, namespaces.Informer().HasSynced) {
+		t.Fatal("caches not synced")
+	}
+
+	// 还有一个使用方时继续运行
+	cm.releaseInformer(podKey)
+	if pods.Informer().IsStopped() {
+		t.Fatal("informer stopped while still referenced")
+	}
+
+	cm.releaseInformer(podKey)
+	err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		return pods.Informer().IsStopped(), nil
+	})
+	if err != nil {
+		t.Fatal("informer not stopped after the last release")
+	}
+	// 同一范围内的其他 informer 不受影响
+	if namespaces.Informer().IsStopped() {
+		t.Fatal("releasing pods stopped the namespace informer")
+	}
+	if cm.acquireInformer(podKey) == pods {
+		t.Fatal("expected a new informer after release")
+	}
+}
@@ -36,6 +36,12 @@ var (
 		Resource: "secrets",
 	}
 
+	CoreV1Node = schema.GroupVersionResource{
+		Group:    "",
+		Version:  "v1",
+		Resource: "nodes",
+	}
+
 	CoreV1Namespace = schema.GroupVersionResource{
 		Group:    "",
 		Version:  "v1",
@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 )
 
@@ -18,42 +21,37 @@ const (
 type (
 	NeedUpdateFunc     func(*unstructured.Unstructured, *unstructured.Unstructured) bool
 	EventHandler       func(ctx context.Context, ctrl *Controller, storage []Dao, obj *unstructured.Unstructured) error
-	EventDeleteHandler func(context.Context, []Dao, string, string) error
+	EventDeleteHandler func(context.Context, []Dao, string, string, DeleteInfo) error
 )
 
+// configPath 配置文件路径，run 收到 SIGHUP 时重新加载
+var configPath string
+
 func main() {
-	kubeconfig := "/Users/jimmygao/.kube/config"
+	flag.StringVar(&configPath, "config", "config.yaml", "path to the YAML/JSON config file")
+	klog.InitFlags(nil)
+	flag.Usage = usage
+	flag.Parse()
+
+	name, args := "run", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
 
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	cfg, err := LoadConfig(configPath)
 	if err != nil {
 		klog.Fatal(err)
 	}
 
-	manager := NewControllerManager("cls-test", config)
-
-	manager.AddDependency(CoreV1Pod, []schema.GroupVersionResource{AppsV1Deployment, AppsV1ReplicaSet})
-	//manager.AddDependency(AppsV1Deployment, []schema.GroupVersionResource{CoreV1Pod})
-
-	manager.RegisterWhitelist(AppsV1Deployment)
-	manager.RegisterWhitelist(AppsV1ReplicaSet)
-	manager.RegisterWhitelist(CoreV1Pod)
-	manager.RegisterWhitelist(AppsV1StatefulSet)
-	manager.RegisterWhitelist(AppsV1ControllerRevision)
-	manager.RegisterWhitelist(AppsV1DaemonSet)
-	manager.RegisterWhitelist(BatchV1Job)
-	manager.RegisterWhitelist(BatchV1CronJob)
-	manager.RegisterWhitelist(CoreV1Service)
-	manager.RegisterWhitelist(CoreV1ConfigMap)
-	manager.RegisterWhitelist(CoreV1Secret)
-	manager.RegisterWhitelist(CoreV1Namespace)
-	manager.RegisterWhitelist(CoreV1PersistentVolume)
-	manager.RegisterWhitelist(CoreV1PersistentVolumeClaim)
-	manager.RegisterWhitelist(NetworkingV1Ingress)
-	manager.RegisterWhitelist(NetworkingV1IngressClass)
-	manager.RegisterWhitelist(StorageV1StorageClass)
-
-	ctx := context.Background()
-	if err := manager.Start(ctx); err != nil {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if err = cmd.run(ctx, cfg, args); err != nil {
 		klog.Fatal(err)
 	}
 }
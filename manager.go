@@ -2,49 +2,81 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
-	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
-	v1 "k8s.io/api/core/v1"
+	"gorm.io/gorm/clause"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 )
 
+const (
+	defaultLeaseName            = "dynamic-controller-leader"
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	serviceAccountTokenFile     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
 type ControllerManager struct {
-	clusterID     string
-	mu            sync.Mutex
-	config        *rest.Config
-	dynamicClient dynamic.Interface
-	kubeClient    kubernetes.Interface
-	controllers   map[schema.GroupVersionResource]*Controller
-	handlerMap    sync.Map
-	needUpdateMap sync.Map
-	whitelist     map[schema.GroupVersionResource]struct{}
-	whitelistMu   sync.RWMutex
-	dependencyMap map[schema.GroupVersionResource][]schema.GroupVersionResource
-	dependencyMu  sync.RWMutex
-	daoMap        map[schema.GroupVersionResource][]Dao
-	daoMu         sync.RWMutex
-	defaultDao    []Dao
-	InClusterMode bool
+	clusterID         string
+	mu                sync.Mutex
+	config            *rest.Config
+	dynamicClient     dynamic.Interface
+	kubeClient        kubernetes.Interface
+	controllers       map[schema.GroupVersionResource]*Controller
+	handlerMap        sync.Map
+	needUpdateMap     sync.Map
+	filter            ResourceFilter
+	whitelistMu       sync.RWMutex
+	dependencyMap     map[schema.GroupResource][]schema.GroupResource
+	dependencyMu      sync.RWMutex
+	daoMap            map[schema.GroupVersionResource][]Dao
+	daoMu             sync.RWMutex
+	defaultDao        []Dao
+	dsn               string
+	dbOptions         DatabaseConfig
+	db                *gorm.DB
+	dbMu              sync.Mutex
+	sharedDB          bool
+	options           map[schema.GroupResource]ResourceConfig
+	optionsMu         sync.RWMutex
+	auditInterval     *metav1.Duration
+	discoveryInterval time.Duration
+	runCtx            context.Context
+	namespaces        *NamespaceConfig
+	transform         *TransformConfig
+	secrets           *secretPolicy
+	nsInformer        informers.GenericInformer
+	sharedInformers   map[informerKey]*sharedInformer
+	informersMu       sync.Mutex
+	kubeconfig        string
+	kubeContext       string
+	leaseNamespace    string
+	leaseName         string
+	InClusterMode     bool
+	// running 运行中的控制器，Start 在关闭数据库前等待它们写完剩余的变更；stopped 后不再启动新的控制器
+	running sync.WaitGroup
+	stopped bool
 }
 
+// NewControllerManager 创建 ControllerManager，config 为空时在启动时根据 InClusterMode 创建
+// 一个 ControllerManager 只能 Start 一次
 func NewControllerManager(clusterID string, config *rest.Config) *ControllerManager {
 	return &ControllerManager{
 		clusterID:     clusterID,
@@ -52,9 +84,78 @@ func NewControllerManager(clusterID string, config *rest.Config) *ControllerMana
 		controllers:   make(map[schema.GroupVersionResource]*Controller),
 		handlerMap:    sync.Map{},
 		needUpdateMap: sync.Map{},
-		whitelist:     make(map[schema.GroupVersionResource]struct{}),
-		dependencyMap: make(map[schema.GroupVersionResource][]schema.GroupVersionResource),
+		dependencyMap: make(map[schema.GroupResource][]schema.GroupResource),
+		options:       make(map[schema.GroupResource]ResourceConfig),
+
+		sharedInformers: make(map[informerKey]*sharedInformer),
+
+		discoveryInterval: defaultDiscoveryInterval,
+	}
+}
+
+// ApplyConfig 根据配置文件注册白名单、依赖和资源选项
+func (cm *ControllerManager) ApplyConfig(cfg *Config) error {
+	filter, err := cfg.ResourceFilter()
+	if err != nil {
+		return err
+	}
+	depMap, err := cfg.DependencyMap()
+	if err != nil {
+		return err
+	}
+	secrets, err := newSecretPolicy(cfg.Secrets)
+	if err != nil {
+		return err
+	}
+
+	cm.dsn = cfg.DSN
+	cm.dbOptions = cfg.Database
+	cm.auditInterval = cfg.AuditInterval
+	cm.discoveryInterval = durationOrDefault(cfg.DiscoveryInterval, defaultDiscoveryInterval)
+	cm.namespaces = cfg.Namespaces
+	cm.transform = cfg.Transform
+	cm.secrets = secrets
+	cm.leaseNamespace = cfg.LeaderElection.Namespace
+	cm.leaseName = cfg.LeaderElection.LeaseName
+	for _, rule := range filter.Include {
+		cm.RegisterWhitelistRule(rule)
+	}
+	for _, rule := range filter.Exclude {
+		cm.RegisterExclude(rule)
+	}
+	for key, opt := range cfg.Resources {
+		if gr, err := ParseGroupResource(key); err == nil {
+			cm.SetResourceOptions(gr, opt)
+		}
 	}
+	for gr, deps := range depMap {
+		if err = cm.AddDependency(gr, deps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyClusterConfig 设置集群连接方式，kubeconfig 和 context 均为空且运行在 Pod 中时使用 ServiceAccount
+func (cm *ControllerManager) ApplyClusterConfig(cluster ClusterConfig) {
+	cm.clusterID = cluster.ID
+	cm.kubeconfig = cluster.Kubeconfig
+	cm.kubeContext = cluster.Context
+	cm.InClusterMode = cluster.InCluster || (cluster.Kubeconfig == "" && cluster.Context == "" && runningInCluster())
+}
+
+// SetResourceOptions 设置资源的选项，对资源的所有版本生效
+func (cm *ControllerManager) SetResourceOptions(gr schema.GroupResource, opt ResourceConfig) {
+	cm.optionsMu.Lock()
+	defer cm.optionsMu.Unlock()
+	cm.options[gr] = opt
+}
+
+// GetResourceOptions 获取 GVR 所属资源的选项
+func (cm *ControllerManager) GetResourceOptions(gvr schema.GroupVersionResource) ResourceConfig {
+	cm.optionsMu.RLock()
+	defer cm.optionsMu.RUnlock()
+	return cm.options[gvr.GroupResource()]
 }
 
 func (cm *ControllerManager) GetController(gvr schema.GroupVersionResource) *Controller {
@@ -66,34 +167,48 @@ func (cm *ControllerManager) GetController(gvr schema.GroupVersionResource) *Con
 	return nil
 }
 
-func (cm *ControllerManager) AddDependency(gvr schema.GroupVersionResource, dependencies []schema.GroupVersionResource) {
+// GetControllerFor 返回资源当前版本的控制器，同一资源只有一个版本在同步
+func (cm *ControllerManager) GetControllerFor(gr schema.GroupResource) *Controller {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	for gvr, ctrl := range cm.controllers {
+		if gvr.GroupResource() == gr {
+			return ctrl
+		}
+	}
+	return nil
+}
+
+// AddDependency 添加依赖，存在循环依赖时返回错误
+func (cm *ControllerManager) AddDependency(gr schema.GroupResource, dependencies []schema.GroupResource) error {
 	cm.dependencyMu.Lock()
 	defer cm.dependencyMu.Unlock()
 
 	// 创建临时依赖副本用于循环检测
 	tempDeps := cloneDependencyMap(cm.dependencyMap)
-	tempDeps[gvr] = append(tempDeps[gvr], dependencies...)
+	tempDeps[gr] = append(tempDeps[gr], dependencies...)
 
 	if HasCycle(tempDeps) {
-		panic(fmt.Errorf("检测到循环依赖: %v -> %v", gvr, dependencies))
+		return fmt.Errorf("检测到循环依赖: %v -> %v", gr, dependencies)
 	}
-	cm.dependencyMap[gvr] = append(cm.dependencyMap[gvr], dependencies...)
+	cm.dependencyMap[gr] = append(cm.dependencyMap[gr], dependencies...)
+	return nil
 }
 
 // 新增深拷贝函数
-func cloneDependencyMap(original map[schema.GroupVersionResource][]schema.GroupVersionResource) map[schema.GroupVersionResource][]schema.GroupVersionResource {
-	clone := make(map[schema.GroupVersionResource][]schema.GroupVersionResource, len(original))
+func cloneDependencyMap(original map[schema.GroupResource][]schema.GroupResource) map[schema.GroupResource][]schema.GroupResource {
+	clone := make(map[schema.GroupResource][]schema.GroupResource, len(original))
 	for k, v := range original {
 		// 对切片进行深拷贝
-		clone[k] = append([]schema.GroupVersionResource(nil), v...)
+		clone[k] = append([]schema.GroupResource(nil), v...)
 	}
 	return clone
 }
 
-func (cm *ControllerManager) GetDependency(gvr schema.GroupVersionResource) []schema.GroupVersionResource {
+func (cm *ControllerManager) GetDependency(gr schema.GroupResource) []schema.GroupResource {
 	cm.dependencyMu.RLock()
 	defer cm.dependencyMu.RUnlock()
-	return cm.dependencyMap[gvr]
+	return cm.dependencyMap[gr]
 }
 
 func (cm *ControllerManager) RegisterNeedUpdate(gvr schema.GroupVersionResource, handler NeedUpdateFunc) {
@@ -101,7 +216,74 @@ func (cm *ControllerManager) RegisterNeedUpdate(gvr schema.GroupVersionResource,
 }
 
 func (cm *ControllerManager) Start(ctx context.Context) error {
+	if err := cm.Init(ctx); err != nil {
+		_ = cm.Close()
+		return err
+	}
+
+	// Start leader election
+	go cm.runLeaderElection(ctx)
+
+	<-ctx.Done()
+	cm.waitControllers()
+	return cm.Close()
+}
+
+// waitControllers 等待所有控制器退出，控制器退出前写入已处理的变更
+func (cm *ControllerManager) waitControllers() {
+	cm.mu.Lock()
+	cm.stopped = true
+	cm.mu.Unlock()
+	cm.running.Wait()
+}
+
+// OpenDB 打开所有控制器共享的数据库连接池，已打开时直接返回
+func (cm *ControllerManager) OpenDB(ctx context.Context) error {
+	cm.dbMu.Lock()
+	defer cm.dbMu.Unlock()
+	if cm.db != nil {
+		return nil
+	}
+	db, err := OpenDatabase(ctx, cm.dsn, cm.dbOptions)
+	if err != nil {
+		return err
+	}
+	cm.db = db
+	return nil
+}
+
+// UseDB 使用外部共享的连接池，Close 时不会关闭
+func (cm *ControllerManager) UseDB(db *gorm.DB) {
+	cm.dbMu.Lock()
+	defer cm.dbMu.Unlock()
+	cm.db = db
+	cm.sharedDB = true
+}
+
+// Close 关闭数据库连接池，共享的连接池由所有者关闭
+func (cm *ControllerManager) Close() error {
+	cm.dbMu.Lock()
+	defer cm.dbMu.Unlock()
+	if cm.db == nil || cm.sharedDB {
+		return nil
+	}
+	err := CloseDatabase(cm.db)
+	cm.db = nil
+	return err
+}
+
+// initClients 创建 kubernetes 客户端
+func (cm *ControllerManager) initClients() error {
+	if cm.kubeClient != nil && cm.dynamicClient != nil {
+		return nil
+	}
 	var err error
+	if cm.config == nil {
+		cm.config, err = cm.loadRestConfig()
+		if err != nil {
+			return err
+		}
+	}
 	cm.kubeClient, err = kubernetes.NewForConfig(cm.config)
 	if err != nil {
 		return err
@@ -111,52 +293,204 @@ func (cm *ControllerManager) Start(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	return nil
+}
 
-	discoveryClient := cm.kubeClient.Discovery()
-	_, resourceLists, err := discoveryClient.ServerGroupsAndResources()
-	if err != nil {
+// loadRestConfig InClusterMode 下使用 ServiceAccount，否则使用 kubeconfig 及指定的 context
+func (cm *ControllerManager) loadRestConfig() (*rest.Config, error) {
+	return loadRestConfig(cm.kubeconfig, cm.kubeContext, cm.InClusterMode)
+}
+
+func loadRestConfig(kubeconfig, kubeContext string, inCluster bool) (*rest.Config, error) {
+	if inCluster {
+		return rest.InClusterConfig()
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = expandHome(kubeconfig)
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+}
+
+// Init 创建客户端并根据服务端发现结果为白名单中的资源创建控制器，不启动控制器
+func (cm *ControllerManager) Init(ctx context.Context) error {
+	if err := cm.initClients(); err != nil {
+		return err
+	}
+	if err := cm.OpenDB(ctx); err != nil {
 		return err
 	}
 
-	for _, resourceList := range resourceLists {
-		var gv schema.GroupVersion
-		gv, err = schema.ParseGroupVersion(resourceList.GroupVersion)
+	return cm.Discover(ctx)
+}
+
+// Migrate 对所有控制器的存储执行迁移，需要先调用 Init
+func (cm *ControllerManager) Migrate(ctx context.Context) error {
+	var errs []error
+	for _, ctrl := range cm.sortedControllers() {
+		if err := ctrl.Migrate(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PurgeCluster 永久删除当前集群在所有资源表及历史表中的记录，包括通配符和分类规则匹配的资源以及已移出白名单的资源，
+// 不需要连接 API Server，需要先调用 OpenDB
+func (cm *ControllerManager) PurgeCluster(ctx context.Context) (int64, error) {
+	db := cm.db.WithContext(ctx)
+	tables, err := syncedTables(db)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		total int64
+		errs  []error
+	)
+	for _, table := range tables {
+		result := db.Exec("DELETE FROM ? WHERE ?", clause.Table{Name: table}, columnEq(columnClusterID, cm.clusterID))
+		if result.Error != nil {
+			errs = append(errs, fmt.Errorf("purge %s: %w", table, result.Error))
+		}
+		total += result.RowsAffected
+	}
+	return total, errors.Join(errs...)
+}
+
+// syncedTableColumns 资源表和历史表共有的列，用于识别数据库中的同步表
+var syncedTableColumns = []string{columnClusterID, columnUID, columnResourceVersion, columnRaw}
+
+// syncedTables 返回数据库中的资源表和历史表，按列识别，不依赖当前的白名单和服务端发现结果
+func syncedTables(db *gorm.DB) ([]string, error) {
+	tables, err := db.Migrator().GetTables()
+	if err != nil {
+		return nil, err
+	}
+	var synced []string
+	for _, table := range tables {
+		columnTypes, err := db.Migrator().ColumnTypes(table)
 		if err != nil {
-			continue
+			return nil, fmt.Errorf("columns of %s: %w", table, err)
+		}
+		columns := make(map[string]bool, len(columnTypes))
+		for _, ct := range columnTypes {
+			columns[ct.Name()] = true
+		}
+		matched := true
+		for _, column := range syncedTableColumns {
+			matched = matched && columns[column]
+		}
+		if matched {
+			synced = append(synced, table)
 		}
+	}
+	sort.Strings(synced)
+	return synced, nil
+}
 
-		for _, resource := range resourceList.APIResources {
-			// 跳过子资源（包含/的resource名称）
-			if strings.Contains(resource.Name, "/") {
-				continue
-			}
-			gvr := gv.WithResource(resource.Name)
-			// 黑名单检查
-			log.Printf("check blacklist for %s\n", gvr.String())
-			if !cm.isWhitelisted(gvr) {
-				log.Printf("Skipping blacklisted GVR: %s\n", gvr)
-				continue
-			}
-			// 检查是否支持list操作
-			if !stringSliceContains(resource.Verbs, "list") {
-				continue
-			}
-			// 检查是否支持watch操作
-			if !stringSliceContains(resource.Verbs, "watch") {
-				continue
-			}
-			cm.createControllerForGVR(gvr, resource.Namespaced)
+// Resync 启动所有 informer，同步完成后清理已删除对象的记录并将全量对象写入存储，需要先调用 Init
+func (cm *ControllerManager) Resync(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
+	controllers := cm.sortedControllers()
+	cm.mu.Lock()
+	cm.startNamespaceInformer(ctx)
+	cm.mu.Unlock()
+	if !cm.waitForNamespaces(ctx.Done()) {
+		return fmt.Errorf("timed out waiting for namespace cache to sync")
+	}
+	for _, ctrl := range controllers {
+		cm.runInformer(ctx, ctrl.GetInformer().Informer())
+	}
+	for _, ctrl := range controllers {
+		if !ctrl.WaitForCacheSync(ctx.Done()) {
+			return fmt.Errorf("timed out waiting for %s caches to sync", ctrl.name)
+		}
+	}
+	for _, ctrl := range controllers {
+		if _, err := ctrl.Reconcile(ctx); err != nil {
+			return err
+		}
+		count, err := ctrl.Resync(ctx)
+		if err != nil {
+			return err
 		}
+		klog.Infof("Resynced %d objects for %s", count, ctrl.name)
 	}
+	return nil
+}
 
-	// Start leader election
-	go cm.runLeaderElection(ctx)
+// Check 检查 API Server 和数据库的连通性
+func (cm *ControllerManager) Check(ctx context.Context) error {
+	if err := cm.initClients(); err != nil {
+		return err
+	}
+	version, err := cm.kubeClient.Discovery().ServerVersion()
+	if err != nil {
+		return fmt.Errorf("api server: %w", err)
+	}
+	klog.Infof("API server %s is reachable", version.GitVersion)
 
-	<-ctx.Done()
+	if err = cm.OpenDB(ctx); err != nil {
+		return fmt.Errorf("database: %w", err)
+	}
+	klog.Info("Database is reachable")
+
+	if err = cm.Init(ctx); err != nil {
+		return fmt.Errorf("discovery: %w", err)
+	}
+	for _, gvr := range cm.Whitelist() {
+		if cm.GetController(gvr) == nil {
+			klog.Warningf("Whitelisted GVR %s is not served by the API server", gvr)
+		}
+	}
 	return nil
 }
 
+// AuditStats 返回所有控制器最近一次审计的统计
+func (cm *ControllerManager) AuditStats() map[schema.GroupVersionResource]AuditStats {
+	stats := make(map[schema.GroupVersionResource]AuditStats)
+	for _, ctrl := range cm.sortedControllers() {
+		stats[ctrl.gvr] = ctrl.AuditStats()
+	}
+	return stats
+}
+
+// Snapshot 根据历史表重建 at 时刻 GVR 下的对象，只读取数据库，需要先调用 OpenDB
+func (cm *ControllerManager) Snapshot(ctx context.Context, gvr schema.GroupVersionResource, namespace string, at time.Time) ([]*unstructured.Unstructured, error) {
+	snapshotDao, ok := cm.GetDao(gvr, false).(SnapshotDao)
+	if !ok {
+		return nil, fmt.Errorf("history is not enabled for %s", gvr)
+	}
+	models, err := snapshotDao.Snapshot(ctx, namespace, at)
+	if err != nil {
+		return nil, err
+	}
+	objs := make([]*unstructured.Unstructured, 0, len(models))
+	for _, model := range models {
+		obj, err := model.ToUnstructured()
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+// sortedControllers 按名称排序返回控制器，保证输出稳定
+func (cm *ControllerManager) sortedControllers() []*Controller {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	controllers := make([]*Controller, 0, len(cm.controllers))
+	for _, ctrl := range cm.controllers {
+		controllers = append(controllers, ctrl)
+	}
+	sort.Slice(controllers, func(i, j int) bool {
+		return controllers[i].name < controllers[j].name
+	})
+	return controllers
+}
+
 func (cm *ControllerManager) createControllerForGVR(gvr schema.GroupVersionResource, namespaced bool) {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
@@ -165,14 +499,48 @@ func (cm *ControllerManager) createControllerForGVR(gvr schema.GroupVersionResou
 		return
 	}
 
-	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(
-		cm.dynamicClient,
-		defaultResyncPeriod,
-		metav1.NamespaceAll,
-		nil,
-	)
-
-	informer := factory.ForResource(gvr)
+	opt := cm.GetResourceOptions(gvr)
+	// 命名空间范围只对 namespaced 资源生效，GVR 配置优先于全局配置
+	var nsConfig *NamespaceConfig
+	if namespaced {
+		nsConfig = opt.Namespaces
+		if nsConfig == nil {
+			nsConfig = cm.namespaces
+		}
+	}
+	scope, err := newNamespaceScope(nsConfig, cm.namespaceLabels)
+	if err != nil {
+		klog.Errorf("Create controller for %s failed: %v", gvr, err)
+		return
+	}
+	if scope.hasSelector() {
+		cm.namespaceInformer()
+	}
+	selector, err := newObjectSelector(opt.LabelSelector, opt.FieldSelector)
+	if err != nil {
+		klog.Errorf("Create controller for %s failed: %v", gvr, err)
+		return
+	}
+	key := informerKey{
+		scope: informerScope{
+			namespace:     scope.informerNamespace(),
+			labelSelector: opt.LabelSelector,
+			fieldSelector: joinFieldSelectors(scope.fieldSelector(), opt.FieldSelector),
+		},
+		gvr: gvr,
+	}
+	// 裁剪规则 GVR 配置优先于全局配置
+	transformConfig := opt.Transform
+	if transformConfig == nil {
+		transformConfig = cm.transform
+	}
+	transform, err := newObjectTransform(transformConfig)
+	if err != nil {
+		klog.Errorf("Create controller for %s failed: %v", gvr, err)
+		return
+	}
+	informer := cm.acquireInformer(key)
+	cm.setInformerTransform(informer.Informer(), transform)
 	queue := workqueue.NewTypedRateLimitingQueueWithConfig(
 		workqueue.DefaultTypedControllerRateLimiter[string](),
 		workqueue.TypedRateLimitingQueueConfig[string]{
@@ -183,24 +551,36 @@ func (cm *ControllerManager) createControllerForGVR(gvr schema.GroupVersionResou
 	unit := NewBase(cm.clusterID, gvr, namespaced, WithStorage(cm.GetDao(gvr, namespaced)))
 
 	ctrl := &Controller{
-		cm:         cm,
-		name:       gvr.String(),
-		gvr:        gvr,
-		namespaced: namespaced,
-		informer:   informer,
-		lister:     informer.Lister(),
-		queue:      queue,
-		dependency: cm.GetDependency(gvr),
-		unit:       unit,
-		clusterID:  cm.clusterID,
-	}
-
-	_, err := informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		cm:                 cm,
+		name:               gvr.String(),
+		gvr:                gvr,
+		namespaced:         namespaced,
+		informer:           informer,
+		lister:             informer.Lister(),
+		queue:              queue,
+		dependency:         cm.GetDependency(gvr.GroupResource()),
+		unit:               unit,
+		clusterID:          cm.clusterID,
+		workers:            opt.WorkersOrDefault(),
+		auditInterval:      opt.AuditIntervalOrDefault(cm.auditInterval),
+		tombstoneRetention: durationOrDefault(opt.TombstoneRetention, 0),
+		scope:              scope,
+		selector:           selector,
+		informerKey:        key,
+	}
+
+	if cm.dbOptions.BatchSize > 0 {
+		ctrl.batcher = newWriteBatcher(ctrl, cm.db, cm.dbOptions.BatchSize, durationOrDefault(cm.dbOptions.BatchInterval, defaultBatchInterval))
+	}
+
+	ctrl.registration, err = informer.Informer().AddEventHandlerWithResyncPeriod(cache.ResourceEventHandlerFuncs{
 		AddFunc:    ctrl.onAdd,
 		UpdateFunc: ctrl.onUpdate,
 		DeleteFunc: ctrl.onDelete,
-	})
+	}, opt.ResyncPeriodOrDefault())
 	if err != nil {
+		klog.Errorf("Create controller for %s failed: %v", gvr, err)
+		cm.releaseInformer(key)
 		return
 	}
 
@@ -208,15 +588,18 @@ func (cm *ControllerManager) createControllerForGVR(gvr schema.GroupVersionResou
 }
 
 func (cm *ControllerManager) runLeaderElection(ctx context.Context) {
+	name := cm.leaseName
+	if name == "" {
+		name = defaultLeaseName
+	}
 	lock := &resourcelock.LeaseLock{
 		LeaseMeta: metav1.ObjectMeta{
-			Name:      "dynamic-controller-leader",
-			Namespace: "default",
+			Name:      name,
+			Namespace: cm.leaderElectionNamespace(),
 		},
 		Client: cm.kubeClient.CoordinationV1(),
 		LockConfig: resourcelock.ResourceLockConfig{
-			// Identity: os.Getenv("POD_NAME"),
-			Identity: "dynamic-controller-leader",
+			Identity: leaderElectionIdentity(),
 		},
 	}
 
@@ -230,6 +613,7 @@ func (cm *ControllerManager) runLeaderElection(ctx context.Context) {
 			OnStartedLeading: func(ctx context.Context) {
 				klog.Info("Started leading")
 				cm.startControllers(ctx)
+				go cm.runDiscovery(ctx)
 			},
 			OnStoppedLeading: func() {
 				klog.Info("Stopped leading")
@@ -238,62 +622,134 @@ func (cm *ControllerManager) runLeaderElection(ctx context.Context) {
 	})
 }
 
+// leaderElectionNamespace Lease 所在命名空间，未配置时使用 Pod 所在命名空间
+func (cm *ControllerManager) leaderElectionNamespace() string {
+	if cm.leaseNamespace != "" {
+		return cm.leaseNamespace
+	}
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		return ns
+	}
+	if cm.InClusterMode {
+		if data, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+			return strings.TrimSpace(string(data))
+		}
+	}
+	return metav1.NamespaceDefault
+}
+
+// leaderElectionIdentity 使用 Pod 名称作为选主身份，不在 Pod 中时使用主机名
+func leaderElectionIdentity() string {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return defaultLeaseName
+	}
+	return hostname
+}
+
 // startControllers 启动控制器
 func (cm *ControllerManager) startControllers(ctx context.Context) {
-	for gvr, ctrl := range cm.controllers {
-		klog.Infof("Starting controller for %s", gvr)
-		go ctrl.Run(ctx)
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.runCtx = ctx
+	for _, ctrl := range cm.controllers {
+		cm.startController(ctrl)
+	}
+}
+
+// startController 在 runCtx 下启动控制器，需要持有 cm.mu，已启动或管理器已停止时直接返回
+func (cm *ControllerManager) startController(ctrl *Controller) {
+	if ctrl.stop != nil || cm.stopped {
+		return
 	}
+	cm.startNamespaceInformer(cm.runCtx)
+	ctx, cancel := context.WithCancel(cm.runCtx)
+	ctrl.stop = cancel
+	klog.Infof("Starting controller for %s", ctrl.gvr)
+	cm.running.Add(1)
+	go func() {
+		defer cm.running.Done()
+		ctrl.Run(ctx)
+	}()
 }
 
 // RegisterWhitelist 添加白名单
 func (cm *ControllerManager) RegisterWhitelist(gvr schema.GroupVersionResource) {
-	log.Printf("Registering blacklist for %s\n", gvr.String())
+	cm.RegisterWhitelistRule(ResourceRule{Group: gvr.Group, Version: gvr.Version, Resource: gvr.Resource})
+}
+
+// RegisterWhitelistRule 添加白名单规则，支持通配符和分类
+func (cm *ControllerManager) RegisterWhitelistRule(rule ResourceRule) {
+	klog.V(2).Infof("Registering whitelist rule %s", rule)
 	cm.whitelistMu.Lock()
 	defer cm.whitelistMu.Unlock()
-	cm.whitelist[gvr] = struct{}{}
+	cm.filter.Include = append(cm.filter.Include, rule)
 }
 
-// isWhitelisted 检查是否在白名单中
-func (cm *ControllerManager) isWhitelisted(gvr schema.GroupVersionResource) bool {
+// RegisterExclude 添加排除规则，排除规则优先于白名单
+func (cm *ControllerManager) RegisterExclude(rule ResourceRule) {
+	klog.V(2).Infof("Registering exclude rule %s", rule)
+	cm.whitelistMu.Lock()
+	defer cm.whitelistMu.Unlock()
+	cm.filter.Exclude = append(cm.filter.Exclude, rule)
+}
+
+// Whitelist 返回白名单中排序后的精确 GVR，不包含通配符和分类规则匹配的资源
+func (cm *ControllerManager) Whitelist() []schema.GroupVersionResource {
 	cm.whitelistMu.RLock()
 	defer cm.whitelistMu.RUnlock()
-	_, ok := cm.whitelist[gvr]
-	return ok
+	return cm.filter.ExactGVRs()
 }
 
-func (cm *ControllerManager) GetDao(gvr schema.GroupVersionResource, namespaced bool) Dao {
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
-	if err != nil {
-		panic(err)
-	}
-
-	if gvr == CoreV1Pod {
-		return NewDao(cm.clusterID, db.Debug(), gvr, namespaced, func(ctx context.Context, model *DynamicModel, obj *unstructured.Unstructured) BaseModel {
-			if obj == nil {
-				return &Pod{
-					DynamicModel: *model,
-					Phase:        "",
-				}
-			}
-			pod := &v1.Pod{}
-			err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, pod)
-			if err != nil {
-				log.Printf(err.Error())
-			}
-			podModel := &Pod{
-				DynamicModel: *model,
-				Phase:        string(pod.Status.Phase),
-			}
+// hasWhitelistPatterns 白名单是否包含通配符或分类规则
+func (cm *ControllerManager) hasWhitelistPatterns() bool {
+	cm.whitelistMu.RLock()
+	defer cm.whitelistMu.RUnlock()
+	return cm.filter.HasPatterns()
+}
 
-			return podModel
-		})
-	}
+// isWhitelisted 检查资源是否匹配白名单且未被排除，categories 为服务端发现结果中的分类，未知时为空
+func (cm *ControllerManager) isWhitelisted(gvr schema.GroupVersionResource, categories []string) bool {
+	cm.whitelistMu.RLock()
+	defer cm.whitelistMu.RUnlock()
+	return cm.filter.Match(gvr, categories)
+}
 
-	return NewDao(cm.clusterID, db.Debug(), gvr, namespaced, nil)
+// isExactWhitelisted 检查 GVR 是否由白名单中的精确规则指定
+func (cm *ControllerManager) isExactWhitelisted(gvr schema.GroupVersionResource) bool {
+	cm.whitelistMu.RLock()
+	defer cm.whitelistMu.RUnlock()
+	return cm.filter.MatchExact(gvr)
 }
 
-type Pod struct {
-	DynamicModel `gorm:"embedded"`
-	Phase        string `gorm:"column:Phase"`
+// GetDao 创建 GVR 对应的 Dao，使用共享连接池，需要先调用 OpenDB
+func (cm *ControllerManager) GetDao(gvr schema.GroupVersionResource, namespaced bool) Dao {
+	opt := cm.GetResourceOptions(gvr)
+	var daoOpts []DaoOption
+	if opt.DeleteMode != "" {
+		daoOpts = append(daoOpts, WithDeleteMode(opt.DeleteMode))
+	}
+	if gvr.GroupResource() == CoreV1Secret.GroupResource() {
+		policy := cm.secrets
+		if policy == nil {
+			policy = defaultSecretPolicy
+		}
+		daoOpts = append(daoOpts, WithSecretPolicy(policy))
+	}
+	if len(opt.Columns) > 0 {
+		// 配置加载时已校验
+		if columns, err := parseColumns(gvr.GroupResource(), opt.Columns); err != nil {
+			klog.Warningf("Parse columns of %s failed: %v", gvr.String(), err)
+		} else {
+			daoOpts = append(daoOpts, WithColumns(columns))
+		}
+	}
+	d := NewDao(cm.clusterID, cm.db, gvr, namespaced, lookupModel(gvr), daoOpts...)
+	if opt.History {
+		d = NewHistoryDao(cm.clusterID, cm.db, d)
+	}
+	return d
 }
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	manifestName       = "kubesync"
+	manifestConfigDir  = "/etc/kubesync"
+	manifestConfigFile = "config.yaml"
+	// manifestKeysDir Secret 加密和 HMAC 密钥的挂载目录，不能位于 manifestConfigDir 下
+	manifestKeysDir = "/etc/kubesync-keys"
+)
+
+// ManifestOptions 生成部署清单的参数
+type ManifestOptions struct {
+	Namespace string
+	Image     string
+}
+
+// GenerateManifests 根据配置生成 Deployment 及最小 RBAC：
+// 白名单资源及 CRD 的 get/list/watch 权限，选主所需的 Lease 权限，以及 hub 模式读取集群 Secret 的权限
+func GenerateManifests(cfg *Config, opts ManifestOptions) ([]runtime.Object, error) {
+	filter, err := cfg.ResourceFilter()
+	if err != nil {
+		return nil, err
+	}
+
+	// 部署到集群后使用 ServiceAccount，多集群模式下保留各集群的连接配置，hub 为部署所在集群
+	podCfg := *cfg
+	if len(podCfg.Clusters) == 0 && podCfg.ClusterID != "" {
+		podCfg.InCluster = true
+		podCfg.Kubeconfig = ""
+		podCfg.Context = ""
+	}
+	if cfg.Hub != nil {
+		hub := *cfg.Hub
+		hub.InCluster = true
+		hub.Kubeconfig = ""
+		hub.Context = ""
+		podCfg.Hub = &hub
+	}
+	// 密钥文件放在单独的 Secret 中挂载，配置中的路径改写为挂载后的路径
+	var keyData map[string][]byte
+	if cfg.Secrets != nil && len(cfg.Secrets.keyFiles()) > 0 {
+		secrets := *cfg.Secrets
+		keyData = make(map[string][]byte)
+		for _, keyFile := range []struct {
+			path *string
+			name string
+		}{
+			{&secrets.EncryptionKeyFile, "encryption.key"},
+			{&secrets.HashKeyFile, "hash.key"},
+		} {
+			if *keyFile.path == "" {
+				continue
+			}
+			data, err := os.ReadFile(*keyFile.path)
+			if err != nil {
+				return nil, fmt.Errorf("read key file: %w", err)
+			}
+			keyData[keyFile.name] = data
+			*keyFile.path = manifestKeysDir + "/" + keyFile.name
+		}
+		podCfg.Secrets = &secrets
+	}
+	configData, err := yaml.Marshal(&podCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	leaseNamespace := cfg.LeaderElection.Namespace
+	if leaseNamespace == "" {
+		leaseNamespace = opts.Namespace
+	}
+	leaseName := cfg.LeaderElection.LeaseName
+	if leaseName == "" {
+		leaseName = defaultLeaseName
+	}
+
+	labels := map[string]string{"app.kubernetes.io/name": manifestName}
+	meta := func(namespace string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: manifestName, Namespace: namespace, Labels: labels}
+	}
+
+	// 按 API 组聚合资源，监听 CRD 以发现新资源；hub 模式需要读取集群 Secret，
+	// 指定了 hub.namespace 时只在该命名空间授权，否则需要读取所有命名空间的 Secret
+	resourcesByGroup := map[string][]string{
+		ApiextensionsV1CRD.Group: {ApiextensionsV1CRD.Resource},
+	}
+	if cfg.Hub != nil && cfg.Hub.Namespace == "" {
+		resourcesByGroup[CoreV1Secret.Group] = []string{CoreV1Secret.Resource}
+	}
+	// 命名空间标签选择器需要读取 Namespace
+	if cfg.usesNamespaceSelector() {
+		resourcesByGroup[CoreV1Namespace.Group] = append(resourcesByGroup[CoreV1Namespace.Group], CoreV1Namespace.Resource)
+	}
+	// 通配符和分类规则只能授予整个组或所有组的权限，RBAC 无法表达排除规则
+	for _, rule := range filter.Include {
+		group, resource := rbacGroupResource(rule)
+		if !stringSliceContains(resourcesByGroup[group], resource) {
+			resourcesByGroup[group] = append(resourcesByGroup[group], resource)
+		}
+	}
+	groups := make([]string, 0, len(resourcesByGroup))
+	for group := range resourcesByGroup {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	rules := make([]rbacv1.PolicyRule, 0, len(groups))
+	for _, group := range groups {
+		resources := resourcesByGroup[group]
+		if stringSliceContains(resources, rbacv1.ResourceAll) {
+			resources = []string{rbacv1.ResourceAll}
+		}
+		sort.Strings(resources)
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{group},
+			Resources: resources,
+			Verbs:     []string{"get", "list", "watch"},
+		})
+	}
+
+	subjects := []rbacv1.Subject{{
+		Kind:      rbacv1.ServiceAccountKind,
+		Name:      manifestName,
+		Namespace: opts.Namespace,
+	}}
+
+	objects := []runtime.Object{
+		&corev1.ServiceAccount{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+			ObjectMeta: meta(opts.Namespace),
+		},
+		&rbacv1.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+			ObjectMeta: meta(""),
+			Rules:      rules,
+		},
+		&rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+			ObjectMeta: meta(""),
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: manifestName},
+			Subjects:   subjects,
+		},
+		&rbacv1.Role{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+			ObjectMeta: meta(leaseNamespace),
+			Rules: []rbacv1.PolicyRule{{
+				APIGroups: []string{coordinationv1.GroupName},
+				Resources: []string{"leases"},
+				Verbs:     []string{"create"},
+			}, {
+				APIGroups:     []string{coordinationv1.GroupName},
+				Resources:     []string{"leases"},
+				ResourceNames: []string{leaseName},
+				Verbs:         []string{"get", "update"},
+			}},
+		},
+		&rbacv1.RoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+			ObjectMeta: meta(leaseNamespace),
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: manifestName},
+			Subjects:   subjects,
+		},
+		// DSN 中包含数据库密码，配置存放在 Secret 中
+		&corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: meta(opts.Namespace),
+			StringData: map[string]string{manifestConfigFile: string(configData)},
+		},
+	}
+	if cfg.Hub != nil && cfg.Hub.Namespace != "" {
+		// 与 Lease 的 Role 可能位于同一命名空间，使用不同的名称
+		hubMeta := meta(cfg.Hub.Namespace)
+		hubMeta.Name = manifestName + "-hub"
+		objects = append(objects,
+			&rbacv1.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+				ObjectMeta: hubMeta,
+				Rules: []rbacv1.PolicyRule{{
+					APIGroups: []string{CoreV1Secret.Group},
+					Resources: []string{CoreV1Secret.Resource},
+					Verbs:     []string{"get", "list", "watch"},
+				}},
+			},
+			&rbacv1.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+				ObjectMeta: hubMeta,
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: hubMeta.Name},
+				Subjects:   subjects,
+			},
+		)
+	}
+
+	volumeMounts := []corev1.VolumeMount{{
+		Name:      "config",
+		MountPath: manifestConfigDir,
+		ReadOnly:  true,
+	}}
+	volumes := []corev1.Volume{{
+		Name: "config",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: manifestName},
+		},
+	}}
+	if keyData != nil {
+		keys := meta(opts.Namespace)
+		keys.Name = manifestName + "-keys"
+		objects = append(objects, &corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: keys,
+			Data:       keyData,
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      "keys",
+			MountPath: manifestKeysDir,
+			ReadOnly:  true,
+		})
+		volumes = append(volumes, corev1.Volume{
+			Name: "keys",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: keys.Name},
+			},
+		})
+	}
+
+	objects = append(objects,
+		&appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: appsv1.SchemeGroupVersion.String(), Kind: "Deployment"},
+			ObjectMeta: meta(opts.Namespace),
+			Spec: appsv1.DeploymentSpec{
+				Replicas: ptr.To[int32](1),
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: labels},
+					Spec: corev1.PodSpec{
+						ServiceAccountName: manifestName,
+						Containers: []corev1.Container{{
+							Name:  manifestName,
+							Image: opts.Image,
+							Args:  []string{"-config", manifestConfigDir + "/" + manifestConfigFile, "run"},
+							Env: []corev1.EnvVar{
+								fieldRefEnv("POD_NAME", "metadata.name"),
+								fieldRefEnv("POD_NAMESPACE", "metadata.namespace"),
+							},
+							VolumeMounts: volumeMounts,
+						}},
+						Volumes: volumes,
+					},
+				},
+			},
+		},
+	)
+	return objects, nil
+}
+
+// rbacGroupResource 将白名单规则转换为 RBAC 的 API 组和资源，含通配符的部分使用 *
+func rbacGroupResource(rule ResourceRule) (string, string) {
+	if rule.IsCategory() {
+		return rbacv1.APIGroupAll, rbacv1.ResourceAll
+	}
+	group, resource := rule.Group, rule.Resource
+	if hasWildcard(group) {
+		group = rbacv1.APIGroupAll
+	}
+	if hasWildcard(resource) {
+		resource = rbacv1.ResourceAll
+	}
+	return group, resource
+}
+
+// WriteManifests 以多文档 YAML 输出部署清单
+func WriteManifests(w io.Writer, objects []runtime.Object) error {
+	for _, obj := range objects {
+		if err := writeYAMLDocument(w, obj); err != nil {
+			return fmt.Errorf("marshal %s: %w", obj.GetObjectKind().GroupVersionKind().Kind, err)
+		}
+	}
+	return nil
+}
+
+func fieldRefEnv(name, fieldPath string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{FieldPath: fieldPath},
+		},
+	}
+}
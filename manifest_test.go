@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// grantsSecrets 规则是否允许读取 Secret
+func grantsSecrets(rules []rbacv1.PolicyRule) bool {
+	for _, rule := range rules {
+		if stringSliceContains(rule.APIGroups, CoreV1Secret.Group) &&
+			(stringSliceContains(rule.Resources, CoreV1Secret.Resource) || stringSliceContains(rule.Resources, rbacv1.ResourceAll)) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestManifestHubSecretRBAC(t *testing.T) {
+	tests := []struct {
+		name string
+		hub  *HubConfig
+		// clusterWide ClusterRole 是否允许读取 Secret
+		clusterWide bool
+		// namespace 授予 Secret 权限的 Role 所在命名空间，为空时不生成
+		namespace string
+	}{
+		{name: "no hub"},
+		{name: "hub namespace", hub: &HubConfig{Namespace: "clusters"}, namespace: "clusters"},
+		{name: "all namespaces", hub: &HubConfig{}, clusterWide: true},
+	}
+	for _, tt := range tests {
+		cfg := &Config{Hub: tt.hub, DSN: "sqlite://:memory:", Whitelist: []string{"v1/pods"}}
+		if tt.hub == nil {
+			cfg.ClusterID = "test"
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		objects, err := GenerateManifests(cfg, ManifestOptions{Namespace: "kubesync", Image: "kubesync:test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var (
+			clusterWide bool
+			namespace   string
+			bound       bool
+		)
+		for _, obj := range objects {
+			switch o := obj.(type) {
+			case *rbacv1.ClusterRole:
+				clusterWide = grantsSecrets(o.Rules)
+			case *rbacv1.Role:
+				if grantsSecrets(o.Rules) {
+					namespace = o.Namespace
+				}
+			case *rbacv1.RoleBinding:
+				if o.RoleRef.Name == manifestName+"-hub" && o.Namespace == tt.namespace {
+					bound = true
+				}
+			}
+		}
+		if clusterWide != tt.clusterWide || namespace != tt.namespace {
+			t.Errorf("%s: unexpected secret permissions: cluster wide %v, namespace %q", tt.name, clusterWide, namespace)
+		}
+		if tt.namespace != "" && !bound {
+			t.Errorf("%s: hub role not bound", tt.name)
+		}
+	}
+}
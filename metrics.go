@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog/v2"
+)
+
+const metricsNamespace = "kubesync"
+
+var (
+	auditDrift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "audit_drift_objects",
+		Help:      "Objects found out of sync by the last audit, by drift type (missing, stale, orphaned).",
+	}, []string{"cluster", "resource", "type"})
+	auditRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "audit_runs_total",
+		Help:      "Audits run between the informer cache and the database.",
+	}, []string{"cluster", "resource"})
+	auditRepairs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "audit_repairs_total",
+		Help:      "Keys enqueued by audits to repair drift.",
+	}, []string{"cluster", "resource"})
+	batchSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "batch_write_objects",
+		Help:      "Objects written per database batch.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 7),
+	}, []string{"cluster", "resource"})
+	batchFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "batch_failures_total",
+		Help:      "Database batches that failed and were retried one object at a time.",
+	}, []string{"cluster", "resource"})
+	staleWrites = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "stale_writes_total",
+		Help:      "Writes rejected because the stored row is newer than the incoming object.",
+	}, []string{"cluster", "resource"})
+)
+
+func init() {
+	prometheus.MustRegister(auditDrift, auditRuns, auditRepairs, batchSize, batchFailures, staleWrites)
+}
+
+// ServeMetrics 在 addr 上提供 /metrics，ctx 取消时关闭
+func ServeMetrics(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	klog.Infof("Serving metrics on %s", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
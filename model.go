@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+)
+
+// ModelFunc 将对象转换为带额外列的模型，obj 为空时返回空模型，用于建表和查询
+type ModelFunc func(ctx context.Context, model *DynamicModel, obj *unstructured.Unstructured) BaseModel
+
+// modelRegistry 类型化模型，同一资源的所有版本共用一张表，按 GroupResource 注册
+var modelRegistry = make(map[schema.GroupResource]ModelFunc)
+
+// RegisterModel 注册资源的类型化模型，需要在创建控制器之前调用，重复注册时覆盖
+func RegisterModel(gr schema.GroupResource, fn ModelFunc) {
+	modelRegistry[gr] = fn
+}
+
+// lookupModel 返回 GVR 对应的类型化模型，未注册时返回空，使用 DynamicModel
+func lookupModel(gvr schema.GroupVersionResource) ModelFunc {
+	return modelRegistry[gvr.GroupResource()]
+}
+
+// typedModel 将对象转换为 T 后由 build 提取列，转换失败时额外列为零值
+func typedModel[T any](build func(model DynamicModel, obj *T) BaseModel) ModelFunc {
+	return func(ctx context.Context, model *DynamicModel, obj *unstructured.Unstructured) BaseModel {
+		typed := new(T)
+		if obj != nil {
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, typed); err != nil {
+				klog.Warningf("Convert %s %s/%s failed: %v", model.Resource, obj.GetNamespace(), obj.GetName(), err)
+			}
+		}
+		return build(*model, typed)
+	}
+}
+
+func init() {
+	RegisterModel(CoreV1Pod.GroupResource(), typedModel(func(model DynamicModel, pod *corev1.Pod) BaseModel {
+		return &Pod{
+			DynamicModel: model,
+			Phase:        string(pod.Status.Phase),
+		}
+	}))
+	RegisterModel(AppsV1Deployment.GroupResource(), typedModel(func(model DynamicModel, deploy *appsv1.Deployment) BaseModel {
+		return &Deployment{
+			DynamicModel:      model,
+			Replicas:          deploy.Spec.Replicas,
+			ReadyReplicas:     deploy.Status.ReadyReplicas,
+			AvailableReplicas: deploy.Status.AvailableReplicas,
+			Images:            containerImages(deploy.Spec.Template.Spec),
+		}
+	}))
+	RegisterModel(CoreV1Service.GroupResource(), typedModel(func(model DynamicModel, svc *corev1.Service) BaseModel {
+		ports := make([]string, 0, len(svc.Spec.Ports))
+		for _, port := range svc.Spec.Ports {
+			ports = append(ports, strconv.Itoa(int(port.Port))+"/"+string(port.Protocol))
+		}
+		return &Service{
+			DynamicModel: model,
+			Type:         string(svc.Spec.Type),
+			ClusterIP:    svc.Spec.ClusterIP,
+			Ports:        strings.Join(ports, ","),
+		}
+	}))
+	RegisterModel(CoreV1Node.GroupResource(), typedModel(func(model DynamicModel, node *corev1.Node) BaseModel {
+		n := &Node{
+			DynamicModel:   model,
+			CapacityCPU:    quantityString(node.Status.Capacity, corev1.ResourceCPU),
+			CapacityMemory: quantityString(node.Status.Capacity, corev1.ResourceMemory),
+			CapacityPods:   quantityString(node.Status.Capacity, corev1.ResourcePods),
+		}
+		// Conditions 只记录状态为 True 的条件，如 Ready,MemoryPressure
+		var active []string
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == corev1.NodeReady {
+				n.Ready = string(condition.Status)
+			}
+			if condition.Status == corev1.ConditionTrue {
+				active = append(active, string(condition.Type))
+			}
+		}
+		n.Conditions = strings.Join(active, ",")
+		return n
+	}))
+	RegisterModel(CoreV1PersistentVolumeClaim.GroupResource(), typedModel(func(model DynamicModel, pvc *corev1.PersistentVolumeClaim) BaseModel {
+		var storageClass string
+		if pvc.Spec.StorageClassName != nil {
+			storageClass = *pvc.Spec.StorageClassName
+		}
+		return &PersistentVolumeClaim{
+			DynamicModel: model,
+			StorageClass: storageClass,
+			Capacity:     quantityString(pvc.Status.Capacity, corev1.ResourceStorage),
+			Phase:        string(pvc.Status.Phase),
+		}
+	}))
+	RegisterModel(BatchV1Job.GroupResource(), typedModel(func(model DynamicModel, job *batchv1.Job) BaseModel {
+		var completionTime *time.Time
+		if job.Status.CompletionTime != nil {
+			completionTime = &job.Status.CompletionTime.Time
+		}
+		return &Job{
+			DynamicModel:   model,
+			Status:         jobStatus(job),
+			Succeeded:      job.Status.Succeeded,
+			Failed:         job.Status.Failed,
+			CompletionTime: completionTime,
+		}
+	}))
+	RegisterModel(BatchV1CronJob.GroupResource(), typedModel(func(model DynamicModel, cronJob *batchv1.CronJob) BaseModel {
+		var lastScheduleTime *time.Time
+		if cronJob.Status.LastScheduleTime != nil {
+			lastScheduleTime = &cronJob.Status.LastScheduleTime.Time
+		}
+		return &CronJob{
+			DynamicModel:     model,
+			Schedule:         cronJob.Spec.Schedule,
+			Suspend:          cronJob.Spec.Suspend != nil && *cronJob.Spec.Suspend,
+			Active:           len(cronJob.Status.Active),
+			LastScheduleTime: lastScheduleTime,
+		}
+	}))
+	RegisterModel(NetworkingV1Ingress.GroupResource(), typedModel(func(model DynamicModel, ingress *networkingv1.Ingress) BaseModel {
+		var class string
+		if ingress.Spec.IngressClassName != nil {
+			class = *ingress.Spec.IngressClassName
+		}
+		hosts := make([]string, 0, len(ingress.Spec.Rules))
+		for _, rule := range ingress.Spec.Rules {
+			if rule.Host != "" && !stringSliceContains(hosts, rule.Host) {
+				hosts = append(hosts, rule.Host)
+			}
+		}
+		return &Ingress{
+			DynamicModel: model,
+			IngressClass: class,
+			Hosts:        strings.Join(hosts, ","),
+		}
+	}))
+}
+
+type Pod struct {
+	DynamicModel `gorm:"embedded"`
+	Phase        string `gorm:"column:Phase;size:32;index"`
+}
+
+type Deployment struct {
+	DynamicModel `gorm:"embedded"`
+	// Replicas 期望副本数，未设置时为 NULL，与缩容到 0 区分
+	Replicas          *int32 `gorm:"column:Replicas"`
+	ReadyReplicas     int32  `gorm:"column:ReadyReplicas"`
+	AvailableReplicas int32  `gorm:"column:AvailableReplicas"`
+	// Images 所有容器（包括 init 容器）的镜像，逗号分隔
+	Images string `gorm:"column:Images;type:text"`
+}
+
+type Service struct {
+	DynamicModel `gorm:"embedded"`
+	Type         string `gorm:"column:Type;size:32;index"`
+	ClusterIP    string `gorm:"column:ClusterIP;size:64;index"`
+	// Ports 端口和协议，如 80/TCP,53/UDP
+	Ports string `gorm:"column:Ports;type:text"`
+}
+
+type Node struct {
+	DynamicModel   `gorm:"embedded"`
+	CapacityCPU    string `gorm:"column:CapacityCPU;size:32"`
+	CapacityMemory string `gorm:"column:CapacityMemory;size:32"`
+	CapacityPods   string `gorm:"column:CapacityPods;size:32"`
+	// Ready Ready 条件的状态：True、False、Unknown
+	Ready      string `gorm:"column:Ready;size:16;index"`
+	Conditions string `gorm:"column:Conditions;size:255"`
+}
+
+type PersistentVolumeClaim struct {
+	DynamicModel `gorm:"embedded"`
+	StorageClass string `gorm:"column:StorageClass;size:255;index"`
+	Capacity     string `gorm:"column:Capacity;size:32"`
+	Phase        string `gorm:"column:Phase;size:32;index"`
+}
+
+type Job struct {
+	DynamicModel `gorm:"embedded"`
+	// Status Complete、Failed、Suspended 或 Running
+	Status         string     `gorm:"column:Status;size:32;index"`
+	Succeeded      int32      `gorm:"column:Succeeded"`
+	Failed         int32      `gorm:"column:Failed"`
+	CompletionTime *time.Time `gorm:"column:CompletionTime"`
+}
+
+type CronJob struct {
+	DynamicModel     `gorm:"embedded"`
+	Schedule         string     `gorm:"column:Schedule;size:255"`
+	Suspend          bool       `gorm:"column:Suspend;index"`
+	Active           int        `gorm:"column:Active"`
+	LastScheduleTime *time.Time `gorm:"column:LastScheduleTime"`
+}
+
+type Ingress struct {
+	DynamicModel `gorm:"embedded"`
+	IngressClass string `gorm:"column:IngressClass;size:255;index"`
+	// Hosts 规则中的域名，逗号分隔
+	Hosts string `gorm:"column:Hosts;type:text"`
+}
+
+// containerImages 返回 Pod 模板中所有容器的镜像，去重并保持顺序
+func containerImages(spec corev1.PodSpec) string {
+	var images []string
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for _, container := range containers {
+			if !stringSliceContains(images, container.Image) {
+				images = append(images, container.Image)
+			}
+		}
+	}
+	return strings.Join(images, ",")
+}
+
+// quantityString 返回资源数量的字符串形式，不存在时为空
+func quantityString(resources corev1.ResourceList, name corev1.ResourceName) string {
+	if q, ok := resources[name]; ok {
+		return q.String()
+	}
+	return ""
+}
+
+// jobStatus 根据 Job 的条件推导状态
+func jobStatus(job *batchv1.Job) string {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete, batchv1.JobFailed, batchv1.JobSuspended:
+			return string(condition.Type)
+		}
+	}
+	if job.Status.Active > 0 || job.Status.StartTime != nil {
+		return "Running"
+	}
+	return ""
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+)
+
+// toModel 使用注册的类型化模型转换对象
+func toModel(t *testing.T, gvr schema.GroupVersionResource, obj any) BaseModel {
+	t.Helper()
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := lookupModel(gvr)
+	if fn == nil {
+		t.Fatalf("no model registered for %s", gvr)
+	}
+	d := NewDao("test", nil, gvr, true, fn)
+	return d.GetModel(context.Background(), &unstructured.Unstructured{Object: content})
+}
+
+func TestDeploymentModel(t *testing.T) {
+	meta := metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "uid-1", ResourceVersion: "1"}
+	tests := []struct {
+		name     string
+		replicas *int32
+	}{
+		{name: "scaled to zero", replicas: ptr.To[int32](0)},
+		{name: "unset", replicas: nil},
+		{name: "three", replicas: ptr.To[int32](3)},
+	}
+	for _, tt := range tests {
+		deploy := &appsv1.Deployment{ObjectMeta: meta, Spec: appsv1.DeploymentSpec{
+			Replicas: tt.replicas,
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "init", Image: "busybox"}},
+				Containers:     []corev1.Container{{Name: "app", Image: "nginx"}, {Name: "sidecar", Image: "busybox"}},
+			}},
+		}}
+		model := toModel(t, AppsV1Deployment, deploy).(*Deployment)
+		if (model.Replicas == nil) != (tt.replicas == nil) || (tt.replicas != nil && *model.Replicas != *tt.replicas) {
+			t.Errorf("%s: expected replicas %v, got %v", tt.name, tt.replicas, model.Replicas)
+		}
+		if model.Images != "busybox,nginx" {
+			t.Errorf("%s: unexpected images %q", tt.name, model.Images)
+		}
+	}
+}
+
+func TestJobModel(t *testing.T) {
+	completed := metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	tests := []struct {
+		name   string
+		status batchv1.JobStatus
+		want   Job
+	}{
+		{
+			name: "succeeded",
+			status: batchv1.JobStatus{
+				Succeeded:      1,
+				CompletionTime: &completed,
+				Conditions:     []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}},
+			},
+			want: Job{Status: string(batchv1.JobComplete), Succeeded: 1, CompletionTime: &completed.Time},
+		},
+		{
+			name: "failed",
+			status: batchv1.JobStatus{
+				Failed:     3,
+				StartTime:  &completed,
+				Conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}},
+			},
+			want: Job{Status: string(batchv1.JobFailed), Failed: 3},
+		},
+		{
+			name:   "running",
+			status: batchv1.JobStatus{Active: 1, StartTime: &completed},
+			want:   Job{Status: "Running"},
+		},
+	}
+	for _, tt := range tests {
+		job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "default"}, Status: tt.status}
+		model := toModel(t, BatchV1Job, job).(*Job)
+		if model.Status != tt.want.Status || model.Succeeded != tt.want.Succeeded || model.Failed != tt.want.Failed {
+			t.Errorf("%s: unexpected status %s succeeded=%d failed=%d", tt.name, model.Status, model.Succeeded, model.Failed)
+		}
+		if (model.CompletionTime == nil) != (tt.want.CompletionTime == nil) ||
+			(model.CompletionTime != nil && !model.CompletionTime.Equal(*tt.want.CompletionTime)) {
+			t.Errorf("%s: unexpected completion time %v", tt.name, model.CompletionTime)
+		}
+	}
+}
+
+func TestCronJobModel(t *testing.T) {
+	scheduled := metav1.NewTime(time.Date(2024, 1, 1, 0, 5, 0, 0, time.UTC))
+	cronJob := &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "default"},
+		Spec:       batchv1.CronJobSpec{Schedule: "*/5 * * * *", Suspend: ptr.To(true)},
+		Status: batchv1.CronJobStatus{
+			Active:           []corev1.ObjectReference{{Name: "backup-1"}, {Name: "backup-2"}},
+			LastScheduleTime: &scheduled,
+		},
+	}
+	model := toModel(t, BatchV1CronJob, cronJob).(*CronJob)
+	if model.Schedule != "*/5 * * * *" || !model.Suspend || model.Active != 2 {
+		t.Fatalf("unexpected model: schedule=%q suspend=%v active=%d", model.Schedule, model.Suspend, model.Active)
+	}
+	if model.LastScheduleTime == nil || !model.LastScheduleTime.Equal(scheduled.Time) {
+		t.Fatalf("unexpected last schedule time %v", model.LastScheduleTime)
+	}
+
+	cronJob.Spec.Suspend = nil
+	cronJob.Status = batchv1.CronJobStatus{}
+	model = toModel(t, BatchV1CronJob, cronJob).(*CronJob)
+	if model.Suspend || model.Active != 0 || model.LastScheduleTime != nil {
+		t.Fatalf("unexpected model for a new cronjob: suspend=%v active=%d last=%v", model.Suspend, model.Active, model.LastScheduleTime)
+	}
+}
+
+func TestIngressModel(t *testing.T) {
+	ingress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+	model := toModel(t, NetworkingV1Ingress, ingress).(*Ingress)
+	if model.Hosts != "" || model.IngressClass != "" {
+		t.Fatalf("unexpected model for an ingress without rules: hosts=%q class=%q", model.Hosts, model.IngressClass)
+	}
+
+	ingress.Spec = networkingv1.IngressSpec{
+		IngressClassName: ptr.To("nginx"),
+		Rules:            []networkingv1.IngressRule{{Host: "a.example.com"}, {}, {Host: "b.example.com"}, {Host: "a.example.com"}},
+	}
+	model = toModel(t, NetworkingV1Ingress, ingress).(*Ingress)
+	if model.Hosts != "a.example.com,b.example.com" || model.IngressClass != "nginx" {
+		t.Fatalf("unexpected model: hosts=%q class=%q", model.Hosts, model.IngressClass)
+	}
+}
+
+func TestCoreModels(t *testing.T) {
+	pod := &corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodRunning}}
+	if got := toModel(t, CoreV1Pod, pod).(*Pod).Phase; got != "Running" {
+		t.Errorf("pod: unexpected phase %q", got)
+	}
+
+	svc := &corev1.Service{Spec: corev1.ServiceSpec{
+		Type:      corev1.ServiceTypeClusterIP,
+		ClusterIP: "10.0.0.1",
+		Ports:     []corev1.ServicePort{{Port: 80, Protocol: corev1.ProtocolTCP}, {Port: 53, Protocol: corev1.ProtocolUDP}},
+	}}
+	if got := toModel(t, CoreV1Service, svc).(*Service); got.Ports != "80/TCP,53/UDP" || got.ClusterIP != "10.0.0.1" {
+		t.Errorf("service: unexpected ports %q clusterIP %q", got.Ports, got.ClusterIP)
+	}
+
+	node := &corev1.Node{Status: corev1.NodeStatus{
+		Capacity: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4"), corev1.ResourcePods: resource.MustParse("110")},
+		Conditions: []corev1.NodeCondition{
+			{Type: corev1.NodeReady, Status: corev1.ConditionFalse},
+			{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionTrue},
+		},
+	}}
+	if got := toModel(t, CoreV1Node, node).(*Node); got.Ready != "False" || got.Conditions != "MemoryPressure" ||
+		got.CapacityCPU != "4" || got.CapacityMemory != "" || got.CapacityPods != "110" {
+		t.Errorf("node: unexpected model %+v", got)
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimPending}}
+	if got := toModel(t, CoreV1PersistentVolumeClaim, pvc).(*PersistentVolumeClaim); got.StorageClass != "" || got.Capacity != "" || got.Phase != "Pending" {
+		t.Errorf("pvc: unexpected model %+v", got)
+	}
+}
+
+func TestTypedModelsMigrate(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	for _, gvr := range []schema.GroupVersionResource{AppsV1Deployment, BatchV1Job, BatchV1CronJob, NetworkingV1Ingress} {
+		d := NewDao("test", db, gvr, true, lookupModel(gvr))
+		if err := d.AutoMigrate(ctx); err != nil {
+			t.Fatalf("migrate %s: %v", gvr, err)
+		}
+	}
+
+	// 未设置的副本数写入 NULL，读取后仍与 0 区分
+	d := NewDao("test", db, AppsV1Deployment, true, lookupModel(AppsV1Deployment))
+	for name, replicas := range map[string]*int32{"unset": nil, "zero": ptr.To[int32](0)} {
+		deploy := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name), ResourceVersion: "1"},
+			Spec:       appsv1.DeploymentSpec{Replicas: replicas},
+		}
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(deploy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = d.Upsert(ctx, &unstructured.Unstructured{Object: content}); err != nil {
+			t.Fatalf("upsert %s: %v", name, err)
+		}
+		stored, err := d.First(ctx, "default", name)
+		if err != nil {
+			t.Fatalf("first %s: %v", name, err)
+		}
+		got := stored.(*Deployment).Replicas
+		if (got == nil) != (replicas == nil) || (got != nil && *got != *replicas) {
+			t.Errorf("%s: expected replicas %v, got %v", name, replicas, got)
+		}
+	}
+}
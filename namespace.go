@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// NamespaceConfig 命名空间范围，只对 namespaced 资源生效
+// Include 为空表示所有命名空间，Exclude 优先于 Include，Selector 为 Namespace 对象的标签选择器
+type NamespaceConfig struct {
+	Include  []string `json:"include,omitempty"`
+	Exclude  []string `json:"exclude,omitempty"`
+	Selector string   `json:"selector,omitempty"`
+}
+
+// Validate 校验命名空间范围
+func (c *NamespaceConfig) Validate() error {
+	for _, ns := range append(append([]string{}, c.Include...), c.Exclude...) {
+		if ns == "" {
+			return fmt.Errorf("namespace must not be empty")
+		}
+	}
+	if _, err := labels.Parse(c.Selector); err != nil {
+		return fmt.Errorf("invalid namespace selector: %w", err)
+	}
+	return nil
+}
+
+// namespaceScope 编译后的命名空间范围
+// 只包含一个命名空间时由 informer 按命名空间 list/watch，排除的命名空间转换为字段选择器，
+// 多个命名空间或标签选择器无法由服务端过滤，在事件处理和 list 时过滤
+type namespaceScope struct {
+	include  map[string]struct{}
+	exclude  map[string]struct{}
+	selector labels.Selector
+	// nsLabels 查询 Namespace 的标签，命名空间不存在时返回 false
+	nsLabels func(namespace string) (labels.Set, bool)
+}
+
+// newNamespaceScope 创建命名空间范围，cfg 为空时表示所有命名空间
+func newNamespaceScope(cfg *NamespaceConfig, nsLabels func(string) (labels.Set, bool)) (*namespaceScope, error) {
+	scope := &namespaceScope{
+		include:  make(map[string]struct{}),
+		exclude:  make(map[string]struct{}),
+		nsLabels: nsLabels,
+	}
+	if cfg == nil {
+		return scope, nil
+	}
+	for _, ns := range cfg.Include {
+		scope.include[ns] = struct{}{}
+	}
+	for _, ns := range cfg.Exclude {
+		scope.exclude[ns] = struct{}{}
+	}
+	if cfg.Selector != "" {
+		selector, err := labels.Parse(cfg.Selector)
+		if err != nil {
+			return nil, err
+		}
+		scope.selector = selector
+	}
+	return scope, nil
+}
+
+// informerNamespace informer list/watch 的命名空间
+func (s *namespaceScope) informerNamespace() string {
+	if len(s.include) == 1 {
+		for ns := range s.include {
+			return ns
+		}
+	}
+	return metav1.NamespaceAll
+}
+
+// fieldSelector 排除命名空间的字段选择器，所有资源都支持 metadata.namespace
+func (s *namespaceScope) fieldSelector() string {
+	if s.informerNamespace() != metav1.NamespaceAll {
+		return ""
+	}
+	selectors := make([]string, 0, len(s.exclude))
+	for ns := range s.exclude {
+		selectors = append(selectors, "metadata.namespace!="+ns)
+	}
+	sort.Strings(selectors)
+	return strings.Join(selectors, ",")
+}
+
+// hasSelector 是否需要 Namespace 的标签
+func (s *namespaceScope) hasSelector() bool {
+	return s.selector != nil
+}
+
+// Match 检查命名空间是否在范围内，cluster 级资源的命名空间为空，总是匹配
+func (s *namespaceScope) Match(namespace string) bool {
+	if namespace == "" {
+		return true
+	}
+	if _, ok := s.exclude[namespace]; ok {
+		return false
+	}
+	if len(s.include) > 0 {
+		if _, ok := s.include[namespace]; !ok {
+			return false
+		}
+	}
+	if s.selector == nil {
+		return true
+	}
+	set, ok := s.nsLabels(namespace)
+	return ok && s.selector.Matches(set)
+}
+
+// namespaceInformer 返回 Namespace informer，不存在时创建，需要持有 cm.mu
+// 只在配置了命名空间标签选择器时使用，由 startNamespaceInformer 启动，与同步 Namespace 的控制器共用
+func (cm *ControllerManager) namespaceInformer() informers.GenericInformer {
+	if cm.nsInformer != nil {
+		return cm.nsInformer
+	}
+	// 不释放引用，同步 Namespace 的控制器停止后仍然保留
+	cm.nsInformer = cm.acquireInformer(informerKey{gvr: CoreV1Namespace})
+	_, err := cm.nsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		// 新建的 Namespace 可能晚于其中的对象到达，对象被过滤后需要重新处理，
+		// 初始 list 在资源 informer 启动之前完成，见 waitForNamespaces
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			if !isInInitialList {
+				cm.requeueNamespace(obj.(*unstructured.Unstructured).GetName(), true)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNs, newNs := oldObj.(*unstructured.Unstructured), newObj.(*unstructured.Unstructured)
+			if !labels.Equals(oldNs.GetLabels(), newNs.GetLabels()) {
+				cm.requeueNamespace(newNs.GetName(), false)
+			}
+		},
+	})
+	if err != nil {
+		klog.Errorf("Watch namespaces failed: %v", err)
+	}
+	return cm.nsInformer
+}
+
+// startNamespaceInformer 启动 Namespace informer，未使用时直接返回，重复调用无副作用
+func (cm *ControllerManager) startNamespaceInformer(ctx context.Context) {
+	if cm.nsInformer != nil {
+		cm.runInformer(ctx, cm.nsInformer.Informer())
+	}
+}
+
+// waitForNamespaces 等待 Namespace informer 同步，未使用时直接返回
+// 需要在资源 informer 启动之前调用，否则初始 list 中的对象会因为命名空间不在缓存中而被过滤
+func (cm *ControllerManager) waitForNamespaces(stopCh <-chan struct{}) bool {
+	cm.mu.Lock()
+	informer := cm.nsInformer
+	cm.mu.Unlock()
+	if informer == nil {
+		return true
+	}
+	return cache.WaitForCacheSync(stopCh, informer.Informer().HasSynced)
+}
+
+// namespaceLabels 从 Namespace informer 缓存中读取标签
+func (cm *ControllerManager) namespaceLabels(namespace string) (labels.Set, bool) {
+	cm.mu.Lock()
+	informer := cm.nsInformer
+	cm.mu.Unlock()
+	if informer == nil {
+		return nil, false
+	}
+	obj, err := informer.Lister().Get(namespace)
+	if err != nil {
+		return nil, false
+	}
+	return obj.(*unstructured.Unstructured).GetLabels(), true
+}
+
+// requeueNamespace Namespace 新建或标签变化后重新处理其中的对象，不再匹配的对象会从存储中删除
+// added 为新建的 Namespace，其中的对象还没有写入存储，不匹配时不需要处理
+func (cm *ControllerManager) requeueNamespace(namespace string, added bool) {
+	for _, ctrl := range cm.sortedControllers() {
+		if !ctrl.namespaced || !ctrl.scope.hasSelector() {
+			continue
+		}
+		if added && !ctrl.scope.Match(namespace) {
+			continue
+		}
+		objs, err := ctrl.lister.ByNamespace(namespace).List(labels.Everything())
+		if err != nil {
+			continue
+		}
+		for _, obj := range objs {
+			uObj := obj.(*unstructured.Unstructured)
+			ctrl.enqueue(ActionUpdate, uObj.GetNamespace(), uObj.GetName())
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestNamespaceScopeMatch(t *testing.T) {
+	nsLabels := func(namespace string) (labels.Set, bool) {
+		switch namespace {
+		case "team-a":
+			return labels.Set{"team": "a"}, true
+		case "team-b":
+			return labels.Set{"team": "b"}, true
+		}
+		return nil, false
+	}
+	tests := []struct {
+		name      string
+		cfg       *NamespaceConfig
+		namespace string
+		want      bool
+	}{
+		{name: "no config", namespace: "default", want: true},
+		{name: "cluster scoped", cfg: &NamespaceConfig{Include: []string{"team-a"}}, namespace: "", want: true},
+		{name: "included", cfg: &NamespaceConfig{Include: []string{"team-a", "team-b"}}, namespace: "team-b", want: true},
+		{name: "not included", cfg: &NamespaceConfig{Include: []string{"team-a"}}, namespace: "default", want: false},
+		{name: "excluded", cfg: &NamespaceConfig{Exclude: []string{"kube-system"}}, namespace: "kube-system", want: false},
+		{name: "exclude wins", cfg: &NamespaceConfig{Include: []string{"team-a"}, Exclude: []string{"team-a"}}, namespace: "team-a", want: false},
+		{name: "selector matches", cfg: &NamespaceConfig{Selector: "team=a"}, namespace: "team-a", want: true},
+		{name: "selector does not match", cfg: &NamespaceConfig{Selector: "team=a"}, namespace: "team-b", want: false},
+		{name: "namespace not cached", cfg: &NamespaceConfig{Selector: "team"}, namespace: "unknown", want: false},
+		{name: "include and selector", cfg: &NamespaceConfig{Include: []string{"team-b"}, Selector: "team"}, namespace: "team-a", want: false},
+	}
+	for _, tt := range tests {
+		scope, err := newNamespaceScope(tt.cfg, nsLabels)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := scope.Match(tt.namespace); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+// newTestNamespace 构造带标签的 Namespace
+func newTestNamespace(name string, nsLabels map[string]string) *unstructured.Unstructured {
+	ns := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata":   map[string]interface{}{"name": name},
+	}}
+	ns.SetLabels(nsLabels)
+	return ns
+}
+
+func TestNamespaceAddRequeuesObjects(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cm := newTestManager()
+	d := newTestDao(t, newTestDB(t))
+	c, indexer := newTestController(t, d)
+	scope, err := newNamespaceScope(&NamespaceConfig{Selector: "team=a"}, cm.namespaceLabels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.cm, c.scope = cm, scope
+	cm.controllers[CoreV1Pod] = c
+
+	cm.mu.Lock()
+	cm.namespaceInformer()
+	cm.startNamespaceInformer(ctx)
+	cm.mu.Unlock()
+	if !cm.waitForNamespaces(ctx.Done()) {
+		t.Fatal("namespace cache not synced")
+	}
+
+	// 对象先于其 Namespace 到达，被过滤
+	for _, namespace := range []string{"team-b", "team-a"} {
+		pod := newTestPod("web", "uid-"+namespace, "1")
+		pod.SetNamespace(namespace)
+		if err = indexer.Add(pod); err != nil {
+			t.Fatal(err)
+		}
+		c.onAdd(pod)
+	}
+	if c.queue.Len() != 0 {
+		t.Fatal("objects in unknown namespaces were enqueued")
+	}
+
+	namespaces := cm.dynamicClient.Resource(CoreV1Namespace)
+	for _, ns := range []*unstructured.Unstructured{
+		newTestNamespace("team-b", map[string]string{"team": "b"}),
+		newTestNamespace("team-a", map[string]string{"team": "a"}),
+	} {
+		if _, err = namespaces.Create(ctx, ns, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		return c.queue.Len() > 0, nil
+	})
+	if err != nil {
+		t.Fatal("object not requeued after its namespace was added")
+	}
+	// 不匹配的 Namespace 新建时不处理其中的对象
+	if c.queue.Len() != 1 {
+		t.Fatalf("expected only the matching namespace to be requeued, got %d keys", c.queue.Len())
+	}
+	key, _ := c.queue.Get()
+	if key != objectKey("team-a", "web") {
+		t.Fatalf("unexpected key %s", key)
+	}
+}
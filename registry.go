@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+)
+
+// ClusterRegistry 在一个进程中为每个集群运行一个 ControllerManager，所有集群共享数据库连接池，
+// 单个集群启动失败或 panic 时按指数退避重启，不影响其他集群
+type ClusterRegistry struct {
+	mu       sync.Mutex
+	cfg      *Config
+	db       *gorm.DB
+	clusters map[string]*clusterEntry
+}
+
+type clusterEntry struct {
+	cluster    ClusterConfig
+	restConfig *rest.Config
+	cancel     context.CancelFunc
+	done       chan struct{}
+
+	mu      sync.Mutex
+	manager *ControllerManager
+}
+
+// NewClusterRegistry 创建 ClusterRegistry，db 由调用方负责关闭
+func NewClusterRegistry(cfg *Config, db *gorm.DB) *ClusterRegistry {
+	return &ClusterRegistry{
+		cfg:      cfg,
+		db:       db,
+		clusters: make(map[string]*clusterEntry),
+	}
+}
+
+// Add 启动一个集群，restConfig 为空时根据 cluster 创建，同 ID 的集群已存在时返回错误
+func (r *ClusterRegistry) Add(ctx context.Context, cluster ClusterConfig, restConfig *rest.Config) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.clusters[cluster.ID]; ok {
+		return fmt.Errorf("cluster %s is already registered", cluster.ID)
+	}
+	r.start(ctx, cluster, restConfig)
+	return nil
+}
+
+// Remove 停止一个集群并等待其退出
+func (r *ClusterRegistry) Remove(id string) bool {
+	r.mu.Lock()
+	entry, ok := r.clusters[id]
+	delete(r.clusters, id)
+	r.mu.Unlock()
+	if !ok {
+		return false
+	}
+	entry.stop()
+	klog.Infof("Cluster %s removed", id)
+	return true
+}
+
+// Sync 使运行中的集群与 cfg 一致：启动新增的集群，停止已移除的集群，重启配置变化的集群
+// 白名单等共享配置变化时重启所有集群；DSN 和连接池配置变化需要重启进程
+func (r *ClusterRegistry) Sync(ctx context.Context, cfg *Config) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	restartAll := !sameSharedConfig(r.cfg, cfg)
+	r.cfg = cfg
+
+	desired := make(map[string]ClusterConfig)
+	for _, cluster := range cfg.ClusterConfigs() {
+		desired[cluster.ID] = cluster
+	}
+
+	var removed, restarted []*clusterEntry
+	for _, entry := range r.clusters {
+		// 通过 Add 传入 restConfig 的集群不受配置文件中的集群列表管理
+		managed := entry.restConfig == nil
+		cluster, ok := desired[entry.cluster.ID]
+		switch {
+		case managed && !ok:
+			removed = append(removed, entry)
+		case restartAll || (managed && cluster != entry.cluster):
+			restarted = append(restarted, entry)
+		}
+	}
+
+	for _, entry := range removed {
+		delete(r.clusters, entry.cluster.ID)
+		entry.stop()
+		klog.Infof("Cluster %s removed", entry.cluster.ID)
+	}
+	for _, entry := range restarted {
+		delete(r.clusters, entry.cluster.ID)
+		entry.stop()
+		cluster := entry.cluster
+		if entry.restConfig == nil {
+			cluster = desired[cluster.ID]
+		}
+		r.start(ctx, cluster, entry.restConfig)
+	}
+	for id, cluster := range desired {
+		if _, ok := r.clusters[id]; !ok {
+			r.start(ctx, cluster, nil)
+		}
+	}
+}
+
+// Purge 永久删除集群在数据库中的所有记录，集群需要先 Remove
+func (r *ClusterRegistry) Purge(ctx context.Context, id string) (int64, error) {
+	r.mu.Lock()
+	cfg := r.cfg
+	r.mu.Unlock()
+	manager := NewControllerManager(id, nil)
+	if err := manager.ApplyConfig(cfg); err != nil {
+		return 0, err
+	}
+	manager.UseDB(r.db)
+	return manager.PurgeCluster(ctx)
+}
+
+// Clusters 返回运行中的集群 ID
+func (r *ClusterRegistry) Clusters() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := make([]string, 0, len(r.clusters))
+	for id := range r.clusters {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Manager 返回集群当前的 ControllerManager，集群未注册或正在重启时返回 nil
+func (r *ClusterRegistry) Manager(id string) *ControllerManager {
+	r.mu.Lock()
+	entry, ok := r.clusters[id]
+	r.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	return entry.manager
+}
+
+// Shutdown 停止所有集群
+func (r *ClusterRegistry) Shutdown() {
+	for _, id := range r.Clusters() {
+		r.Remove(id)
+	}
+}
+
+// start 需要持有 r.mu
+func (r *ClusterRegistry) start(ctx context.Context, cluster ClusterConfig, restConfig *rest.Config) {
+	ctx, cancel := context.WithCancel(ctx)
+	entry := &clusterEntry{
+		cluster:    cluster,
+		restConfig: restConfig,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+	r.clusters[cluster.ID] = entry
+	cfg := r.cfg
+	go entry.run(ctx, cfg, r.db)
+	klog.Infof("Cluster %s added", cluster.ID)
+}
+
+func (e *clusterEntry) stop() {
+	e.cancel()
+	<-e.done
+}
+
+// run 运行集群的 ControllerManager，失败时按指数退避重试，直到 ctx 取消
+func (e *clusterEntry) run(ctx context.Context, cfg *Config, db *gorm.DB) {
+	defer close(e.done)
+	backoff := wait.Backoff{
+		Duration: 5 * time.Second,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    10,
+		Cap:      5 * time.Minute,
+	}
+	for {
+		err := e.runOnce(ctx, cfg, db)
+		if ctx.Err() != nil {
+			return
+		}
+		delay := backoff.Step()
+		klog.Errorf("Cluster %s stopped: %v, restarting in %s", e.cluster.ID, err, delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (e *clusterEntry) runOnce(ctx context.Context, cfg *Config, db *gorm.DB) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	// 每次重启使用新的 ControllerManager，informer 只能启动一次
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	manager := NewControllerManager(e.cluster.ID, e.restConfig)
+	if err = manager.ApplyConfig(cfg); err != nil {
+		return err
+	}
+	manager.ApplyClusterConfig(e.cluster)
+	manager.UseDB(db)
+
+	e.mu.Lock()
+	e.manager = manager
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		e.manager = nil
+		e.mu.Unlock()
+	}()
+
+	if err = manager.Start(ctx); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// sameSharedConfig 比较除集群列表外的配置是否相同
+func sameSharedConfig(a, b *Config) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	x, y := *a, *b
+	x.Clusters, y.Clusters = nil, nil
+	x.ClusterID, y.ClusterID = "", ""
+	x.Kubeconfig, y.Kubeconfig = "", ""
+	x.Context, y.Context = "", ""
+	x.InCluster, y.InCluster = false, false
+	return reflect.DeepEqual(x, y)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+)
+
+// unreachableConfig 指向不可达的 API Server，集群启动失败后按退避重试，直到被移除
+func unreachableConfig() *rest.Config {
+	return &rest.Config{Host: "https://127.0.0.1:1"}
+}
+
+func TestClusterRegistryAddRemove(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := &Config{Whitelist: []string{"v1/pods"}}
+	r := NewClusterRegistry(cfg, newTestDB(t))
+	defer r.Shutdown()
+
+	for _, id := range []string{"b", "a"} {
+		if err := r.Add(ctx, ClusterConfig{ID: id}, unreachableConfig()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Add(ctx, ClusterConfig{ID: "a"}, unreachableConfig()); err == nil {
+		t.Fatal("expected duplicate cluster to be rejected")
+	}
+	if got := r.Clusters(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("unexpected clusters %v", got)
+	}
+
+	if !r.Remove("a") || r.Remove("a") {
+		t.Fatal("expected a to be removed exactly once")
+	}
+	// 通过 Add 注册的集群不受配置文件中的集群列表管理
+	r.Sync(ctx, cfg)
+	if got := r.Clusters(); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Fatalf("unexpected clusters after sync %v", got)
+	}
+	r.Shutdown()
+	if got := r.Clusters(); len(got) != 0 {
+		t.Fatalf("clusters left after shutdown: %v", got)
+	}
+}
+
+// countRows 返回表中属于集群的记录数
+func countRows(t *testing.T, r *ClusterRegistry, table, clusterID string) int64 {
+	t.Helper()
+	var n int64
+	if err := r.db.Table(table).Where(columnEq(columnClusterID, clusterID)).Count(&n).Error; err != nil {
+		t.Fatalf("count %s: %v", table, err)
+	}
+	return n
+}
+
+func TestClusterRegistryPurge(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	// 只由通配符匹配的资源，以及已移出白名单的资源
+	gateway := schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "gateways"}
+	gvrs := []schema.GroupVersionResource{CoreV1Pod, AppsV1Deployment, gateway}
+	var tables []string
+	for _, cluster := range []string{"a", "b"} {
+		for _, gvr := range gvrs {
+			d := NewHistoryDao(cluster, db, NewDao(cluster, db, gvr, true, nil))
+			if err := d.AutoMigrate(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := d.Upsert(ctx, newTestPod("web", "uid-"+cluster, "1")); err != nil {
+				t.Fatal(err)
+			}
+			if cluster == "a" {
+				tables = append(tables, d.TableName(ctx), d.TableName(ctx)+historyTableSuffix)
+			}
+		}
+	}
+	// 其他程序的表即使有 ClusterID 列也不受影响
+	if err := db.Exec(`CREATE TABLE other ("ClusterID" text)`).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(`INSERT INTO other VALUES ('a')`).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewClusterRegistry(&Config{Whitelist: []string{"v1/pods", "*.networking.k8s.io/*/*"}}, db)
+	n, err := r.Purge(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(tables)) {
+		t.Fatalf("expected %d rows purged, got %d", len(tables), n)
+	}
+	for _, table := range tables {
+		if countRows(t, r, table, "a") != 0 || countRows(t, r, table, "b") != 1 {
+			t.Errorf("%s: unexpected rows after purge", table)
+		}
+	}
+	if countRows(t, r, "other", "a") != 1 {
+		t.Error("unrelated table was purged")
+	}
+}
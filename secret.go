@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Secret 脱敏模式
+const (
+	// RedactionDrop 移除 data 和 stringData
+	RedactionDrop = "drop"
+	// RedactionHash 将每个值替换为 HMAC-SHA256 摘要，值变化仍可被发现，没有密钥时无法暴力破解
+	RedactionHash = "hash"
+	// RedactionKeys 只保留键，值替换为空字符串，未配置时的默认模式
+	RedactionKeys = "keys"
+	// RedactionNone 不脱敏，只能与 encryptionKeyFile 一起使用
+	RedactionNone = "none"
+)
+
+// lastAppliedAnnotation kubectl apply 记录的完整对象，Secret 的该注解包含明文数据
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// encryptedRawPrefix 加密后 Raw 的前缀，格式为 enc:v1:<keyID>:<加密的数据密钥>:<密文>
+const encryptedRawPrefix = "enc:v1:"
+
+// SecretConfig Secret 的脱敏和加密配置，在写入数据库前生效，同时作用于历史表
+// 未配置时使用 keys 模式，Secret 的值不会以明文写入数据库
+type SecretConfig struct {
+	// Redaction 脱敏模式：drop、hash、keys、none，为空时为 keys
+	Redaction string `json:"redaction,omitempty"`
+	// HashKeyFile hash 模式的 HMAC 密钥文件，格式同 EncryptionKeyFile，使用第一行的密钥
+	HashKeyFile string `json:"hashKeyFile,omitempty"`
+	// EncryptionKeyFile 密钥文件，每行一个 base64 编码的 32 字节密钥，第一行用于加密，其余行只用于解密旧数据
+	// 设置后 Raw 列使用信封加密：每条记录使用随机数据密钥 AES-256-GCM 加密，数据密钥再由文件中的密钥加密
+	EncryptionKeyFile string `json:"encryptionKeyFile,omitempty"`
+}
+
+// Validate 校验脱敏模式并读取密钥文件
+func (c *SecretConfig) Validate() error {
+	switch c.Redaction {
+	case "", RedactionDrop, RedactionKeys:
+	case RedactionHash:
+		if c.HashKeyFile == "" {
+			return fmt.Errorf("redaction %s requires hashKeyFile", RedactionHash)
+		}
+	case RedactionNone:
+		if c.EncryptionKeyFile == "" {
+			return fmt.Errorf("redaction %s requires encryptionKeyFile", RedactionNone)
+		}
+	default:
+		return fmt.Errorf("unknown redaction %q", c.Redaction)
+	}
+	for _, path := range c.keyFiles() {
+		if _, err := loadEncryptionKeys(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyFiles 返回配置的密钥文件
+func (c *SecretConfig) keyFiles() []string {
+	var files []string
+	for _, path := range []string{c.EncryptionKeyFile, c.HashKeyFile} {
+		if path != "" {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// encryptionKey 密钥文件中的一个密钥，id 为密钥摘要的前 8 个字节，用于解密时选择密钥
+type encryptionKey struct {
+	id  string
+	key []byte
+}
+
+// loadEncryptionKeys 读取密钥文件，忽略空行和 # 开头的注释
+func loadEncryptionKeys(path string) ([]encryptionKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read encryption key file: %w", err)
+	}
+	var keys []encryptionKey
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key file %s line %d: %w", path, i+1, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("encryption key file %s line %d: key must be 32 bytes, got %d", path, i+1, len(key))
+		}
+		sum := sha256.Sum256(key)
+		keys = append(keys, encryptionKey{id: hex.EncodeToString(sum[:8]), key: key})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("encryption key file %s: no key found", path)
+	}
+	return keys, nil
+}
+
+// decryptionKeys 所有加载过的密钥，按 id 索引
+// ToUnstructured 无法访问配置，解密时从这里查找密钥；重新加载配置后旧密钥仍保留，已加密的数据可继续读取
+var decryptionKeys = struct {
+	sync.RWMutex
+	keys map[string][]byte
+}{keys: make(map[string][]byte)}
+
+// registerDecryptionKeys 注册用于解密的密钥
+func registerDecryptionKeys(keys []encryptionKey) {
+	decryptionKeys.Lock()
+	defer decryptionKeys.Unlock()
+	for _, k := range keys {
+		decryptionKeys.keys[k.id] = k.key
+	}
+}
+
+// secretPolicy 编译后的 Secret 配置
+type secretPolicy struct {
+	redaction string
+	// key 加密密钥，为空时不加密
+	key *encryptionKey
+	// hashKey hash 模式的 HMAC 密钥
+	hashKey []byte
+}
+
+// defaultSecretPolicy 未配置 secrets 时使用，只保留键
+var defaultSecretPolicy = &secretPolicy{redaction: RedactionKeys}
+
+// newSecretPolicy 读取密钥并注册到解密密钥中，cfg 为空时返回 defaultSecretPolicy
+func newSecretPolicy(cfg *SecretConfig) (*secretPolicy, error) {
+	if cfg == nil {
+		return defaultSecretPolicy, nil
+	}
+	policy := &secretPolicy{redaction: cfg.Redaction}
+	if policy.redaction == "" {
+		policy.redaction = RedactionKeys
+	}
+	if cfg.HashKeyFile != "" {
+		keys, err := loadEncryptionKeys(cfg.HashKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		policy.hashKey = keys[0].key
+	}
+	if cfg.EncryptionKeyFile != "" {
+		keys, err := loadEncryptionKeys(cfg.EncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		registerDecryptionKeys(keys)
+		policy.key = &keys[0]
+	}
+	return policy, nil
+}
+
+// redact 返回脱敏后的副本，不修改缓存中的对象
+// last-applied-configuration 注解包含明文数据且会写入未加密的 Annotations 列，总是移除
+func (p *secretPolicy) redact(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	if _, ok := obj.GetAnnotations()[lastAppliedAnnotation]; !ok && p.redaction == RedactionNone {
+		return obj
+	}
+	obj = obj.DeepCopy()
+	annotations := obj.GetAnnotations()
+	if _, ok := annotations[lastAppliedAnnotation]; ok {
+		delete(annotations, lastAppliedAnnotation)
+		obj.SetAnnotations(annotations)
+	}
+	for _, field := range []string{"data", "stringData"} {
+		values, ok := obj.Object[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch p.redaction {
+		case RedactionDrop:
+			delete(obj.Object, field)
+		case RedactionHash:
+			for k, v := range values {
+				mac := hmac.New(sha256.New, p.hashKey)
+				mac.Write([]byte(fmt.Sprint(v)))
+				values[k] = "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+			}
+		case RedactionKeys:
+			for k := range values {
+				values[k] = ""
+			}
+		}
+	}
+	return obj
+}
+
+// encrypt 加密 Raw，未配置密钥时原样返回
+func (p *secretPolicy) encrypt(raw string) (string, error) {
+	if p.key == nil {
+		return raw, nil
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	wrappedKey, err := sealAESGCM(p.key.key, dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := sealAESGCM(dataKey, []byte(raw))
+	if err != nil {
+		return "", err
+	}
+	return encryptedRawPrefix + p.key.id + ":" +
+		base64.StdEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// isEncryptedRaw Raw 是否为加密格式
+func isEncryptedRaw(raw string) bool {
+	return strings.HasPrefix(raw, encryptedRawPrefix)
+}
+
+// decryptRaw 解密 encrypt 生成的 Raw，密钥需要先通过配置加载
+func decryptRaw(raw string) (string, error) {
+	parts := strings.Split(strings.TrimPrefix(raw, encryptedRawPrefix), ":")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("invalid encrypted raw")
+	}
+	decryptionKeys.RLock()
+	key, ok := decryptionKeys.keys[parts[0]]
+	decryptionKeys.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("encryption key %s not loaded", parts[0])
+	}
+	wrappedKey, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted raw: %w", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted raw: %w", err)
+	}
+	dataKey, err := openAESGCM(key, wrappedKey)
+	if err != nil {
+		return "", fmt.Errorf("decrypt data key: %w", err)
+	}
+	plaintext, err := openAESGCM(dataKey, ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("decrypt raw: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// sealAESGCM AES-GCM 加密，返回 nonce 与密文的拼接
+func sealAESGCM(key, plaintext []byte) ([]byte, error) {
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// openAESGCM sealAESGCM 的逆操作
+func openAESGCM(key, sealed []byte) ([]byte, error) {
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// writeTestKey 在临时目录写入一个密钥文件
+func writeTestKey(t *testing.T, name string, seed byte) string {
+	t.Helper()
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = seed
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newTestSecret 构造测试用的 Secret
+func newTestSecret() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]interface{}{
+			"name":            "db",
+			"namespace":       "default",
+			"uid":             "uid-secret",
+			"resourceVersion": "1",
+		},
+		"data": map[string]interface{}{"password": "aHVudGVyMg=="},
+	}}
+}
+
+func TestSecretHashUsesHMAC(t *testing.T) {
+	redactWith := func(path string) string {
+		policy, err := newSecretPolicy(&SecretConfig{Redaction: RedactionHash, HashKeyFile: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, _, _ := unstructured.NestedString(policy.redact(newTestSecret()).Object, "data", "password")
+		return value
+	}
+
+	first := redactWith(writeTestKey(t, "a.key", 1))
+	if !strings.HasPrefix(first, "hmac-sha256:") {
+		t.Fatalf("unexpected hash %q", first)
+	}
+	plain := sha256.Sum256([]byte("aHVudGVyMg=="))
+	if strings.HasSuffix(first, hex.EncodeToString(plain[:])) {
+		t.Fatal("hash is an unsalted sha256 of the value")
+	}
+	if redactWith(writeTestKey(t, "b.key", 2)) == first {
+		t.Fatal("different keys produced the same hash")
+	}
+
+	if err := (&SecretConfig{Redaction: RedactionHash}).Validate(); err == nil {
+		t.Fatal("expected hash without hashKeyFile to be rejected")
+	}
+	if err := (&SecretConfig{Redaction: RedactionNone}).Validate(); err == nil {
+		t.Fatal("expected none without encryptionKeyFile to be rejected")
+	}
+}
+
+func TestSecretsRedactedByDefault(t *testing.T) {
+	ctx := context.Background()
+	cm := NewControllerManager("test", nil)
+	cm.UseDB(newTestDB(t))
+	d := cm.GetDao(CoreV1Secret, true)
+	if err := d.AutoMigrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Upsert(ctx, newTestSecret()); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := d.First(ctx, "default", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stored.GetRaw(), "aHVudGVyMg==") {
+		t.Fatal("secret value stored verbatim without a secrets config")
+	}
+	obj, err := stored.ToUnstructured()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, found, _ := unstructured.NestedString(obj.Object, "data", "password"); !found || value != "" {
+		t.Fatalf("expected key to be kept with an empty value, got %q found=%v", value, found)
+	}
+}
+
+func TestManifestMountsKeyFiles(t *testing.T) {
+	keyFile := writeTestKey(t, "encryption.key", 3)
+	cfg := &Config{
+		ClusterID: "test",
+		DSN:       "sqlite://:memory:",
+		Whitelist: []string{"v1/secrets"},
+		Secrets:   &SecretConfig{EncryptionKeyFile: keyFile},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := GenerateManifests(cfg, ManifestOptions{Namespace: "kubesync", Image: "kubesync:test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		podCfg     Config
+		keys       *corev1.Secret
+		deployment *appsv1.Deployment
+	)
+	for _, obj := range objects {
+		switch o := obj.(type) {
+		case *corev1.Secret:
+			if o.Name == manifestName {
+				if err = yaml.Unmarshal([]byte(o.StringData[manifestConfigFile]), &podCfg); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				keys = o
+			}
+		case *appsv1.Deployment:
+			deployment = o
+		}
+	}
+	if keys == nil || deployment == nil {
+		t.Fatal("key secret or deployment not generated")
+	}
+	want, _ := os.ReadFile(keyFile)
+	if string(keys.Data["encryption.key"]) != string(want) {
+		t.Fatal("key secret does not contain the key file")
+	}
+	if podCfg.Secrets.EncryptionKeyFile != manifestKeysDir+"/encryption.key" {
+		t.Fatalf("encryptionKeyFile not rewritten: %s", podCfg.Secrets.EncryptionKeyFile)
+	}
+
+	mounted := false
+	for _, mount := range deployment.Spec.Template.Spec.Containers[0].VolumeMounts {
+		if mount.MountPath == manifestKeysDir {
+			mounted = true
+		}
+	}
+	if !mounted {
+		t.Fatal("key secret is not mounted")
+	}
+}
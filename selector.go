@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// objectSelector GVR 配置的标签和字段选择器，由 API Server 在 list/watch 时过滤
+// 对象修改后不再匹配时 watch 返回删除事件，记录从存储中删除
+type objectSelector struct {
+	label labels.Selector
+	field fields.Selector
+}
+
+// newObjectSelector 解析选择器，均为空时返回匹配所有对象的选择器
+func newObjectSelector(labelSelector, fieldSelector string) (*objectSelector, error) {
+	label, err := labels.Parse(labelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid labelSelector: %w", err)
+	}
+	field, err := fields.ParseSelector(fieldSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fieldSelector: %w", err)
+	}
+	return &objectSelector{label: label, field: field}, nil
+}
+
+// Matches 在本地判断对象是否匹配选择器，用于区分删除事件的原因
+// 字段按路径从对象中读取，与 API Server 支持的字段选择器语义基本一致
+func (s *objectSelector) Matches(obj *unstructured.Unstructured) bool {
+	if !s.label.Matches(labels.Set(obj.GetLabels())) {
+		return false
+	}
+	if s.field.Empty() {
+		return true
+	}
+	set := fields.Set{}
+	for _, req := range s.field.Requirements() {
+		value, found, err := unstructured.NestedFieldNoCopy(obj.Object, strings.Split(req.Field, ".")...)
+		if err != nil || !found || value == nil {
+			set[req.Field] = ""
+			continue
+		}
+		set[req.Field] = fmt.Sprint(value)
+	}
+	return s.field.Matches(set)
+}
+
+// joinFieldSelectors 合并多个字段选择器，各条件同时满足
+func joinFieldSelectors(selectors ...string) string {
+	nonEmpty := make([]string, 0, len(selectors))
+	for _, selector := range selectors {
+		if selector != "" {
+			nonEmpty = append(nonEmpty, selector)
+		}
+	}
+	return strings.Join(nonEmpty, ",")
+}
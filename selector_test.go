@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestObjectSelectorMatches(t *testing.T) {
+	pod := func(podLabels map[string]string, phase string) *unstructured.Unstructured {
+		obj := newTestPod("web", "uid-1", "1")
+		obj.SetLabels(podLabels)
+		if phase != "" {
+			_ = unstructured.SetNestedField(obj.Object, phase, "status", "phase")
+		}
+		return obj
+	}
+	tests := []struct {
+		name  string
+		label string
+		field string
+		obj   *unstructured.Unstructured
+		want  bool
+	}{
+		{name: "empty selectors", obj: pod(nil, ""), want: true},
+		{name: "label equals", label: "app=web", obj: pod(map[string]string{"app": "web"}, ""), want: true},
+		{name: "label differs", label: "app=web", obj: pod(map[string]string{"app": "api"}, ""), want: false},
+		{name: "label missing", label: "app", obj: pod(nil, ""), want: false},
+		{name: "label not exists", label: "!canary", obj: pod(map[string]string{"canary": "true"}, ""), want: false},
+		{name: "label in set", label: "tier in (frontend,backend)", obj: pod(map[string]string{"tier": "backend"}, ""), want: true},
+		{name: "field equals", field: "spec.nodeName=node-1", obj: pod(nil, ""), want: true},
+		{name: "field differs", field: "spec.nodeName=node-2", obj: pod(nil, ""), want: false},
+		{name: "field not equals", field: "status.phase!=Succeeded", obj: pod(nil, "Running"), want: true},
+		{name: "missing field equals empty", field: "status.phase=", obj: pod(nil, ""), want: true},
+		{name: "missing field not equals", field: "status.phase!=Running", obj: pod(nil, ""), want: true},
+		{name: "metadata field", field: "metadata.namespace=default", obj: pod(nil, ""), want: true},
+		{name: "multiple fields", field: "spec.nodeName=node-1,status.phase=Failed", obj: pod(nil, "Running"), want: false},
+		{name: "label and field", label: "app=web", field: "spec.nodeName=node-1", obj: pod(map[string]string{"app": "web"}, ""), want: true},
+		{name: "field matches but label not", label: "app=web", field: "spec.nodeName=node-1", obj: pod(nil, ""), want: false},
+	}
+	for _, tt := range tests {
+		selector, err := newObjectSelector(tt.label, tt.field)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := selector.Matches(tt.obj); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	for _, tt := range []struct{ label, field string }{{label: "app in (web"}, {field: "spec.nodeName"}} {
+		if _, err := newObjectSelector(tt.label, tt.field); err == nil {
+			t.Errorf("expected %q %q to be rejected", tt.label, tt.field)
+		}
+	}
+}
+
+func TestSelectorScopeDelete(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	d := newTestDao(t, db)
+	tests := []struct {
+		name    string
+		label   string
+		field   string
+		updated func(obj *unstructured.Unstructured)
+		want    string
+	}{
+		{
+			name:    "label removed",
+			label:   "app=web",
+			updated: func(obj *unstructured.Unstructured) { obj.SetLabels(map[string]string{"app": "api"}) },
+			want:    DeleteReasonFiltered,
+		},
+		{
+			name:  "field changed",
+			field: "spec.nodeName=node-1",
+			updated: func(obj *unstructured.Unstructured) {
+				_ = unstructured.SetNestedField(obj.Object, "node-2", "spec", "nodeName")
+			},
+			want: DeleteReasonFiltered,
+		},
+		{
+			name:  "deleted while matching",
+			label: "app=web",
+			updated: func(obj *unstructured.Unstructured) {
+				now := metav1.Now()
+				obj.SetDeletionTimestamp(&now)
+			},
+			want: DeleteReasonWatch,
+		},
+		{
+			name:  "deleted after leaving scope",
+			label: "app=web",
+			updated: func(obj *unstructured.Unstructured) {
+				now := metav1.Now()
+				obj.SetLabels(nil)
+				obj.SetDeletionTimestamp(&now)
+			},
+			want: DeleteReasonWatch,
+		},
+	}
+	for i, tt := range tests {
+		c, indexer := newTestController(t, d)
+		selector, err := newObjectSelector(tt.label, tt.field)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.selector = selector
+
+		uid := "uid-" + string(rune('a'+i))
+		pod := newTestPod("web", uid, "1")
+		pod.SetLabels(map[string]string{"app": "web"})
+		if err = indexer.Add(pod); err != nil {
+			t.Fatal(err)
+		}
+		c.onAdd(pod)
+		c.processNextItem()
+		if reason := deletedReason(t, db, d, uid); reason != "" {
+			t.Fatalf("%s: matching object not stored: %q", tt.name, reason)
+		}
+
+		// API Server 对修改后不再匹配选择器的对象返回删除事件，informer 将其移出缓存
+		updated := pod.DeepCopy()
+		updated.SetResourceVersion("2")
+		tt.updated(updated)
+		if err = indexer.Delete(updated); err != nil {
+			t.Fatal(err)
+		}
+		c.onDelete(updated)
+		c.processNextItem()
+		if reason := deletedReason(t, db, d, uid); reason != tt.want {
+			t.Errorf("%s: expected tombstone with reason %s, got %q", tt.name, tt.want, reason)
+		}
+		if _, err = d.First(ctx, "default", "web"); err == nil {
+			t.Errorf("%s: object out of scope still stored", tt.name)
+		}
+	}
+}
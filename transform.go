@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// defaultDropPaths 默认移除的字段，对同步没有意义且体积较大
+var defaultDropPaths = []string{
+	"metadata.managedFields",
+	`metadata.annotations["kubectl.kubernetes.io/last-applied-configuration"]`,
+}
+
+// TransformConfig 对象进入 informer 缓存前的裁剪规则，缓存和数据库中保存的都是裁剪后的对象
+// 路径以 . 分隔，包含 . 或 / 的键使用 ["key"]，[*] 匹配数组的所有元素或对象的所有键，
+// 如 status.conditions[*].lastHeartbeatTime
+type TransformConfig struct {
+	// Drop 移除的字段，路径不存在时忽略
+	Drop []string `json:"drop,omitempty"`
+	// Set 将已存在的字段改写为固定值，不会创建字段
+	Set map[string]interface{} `json:"set,omitempty"`
+	// SkipDefaults 不移除 defaultDropPaths 中的字段
+	SkipDefaults bool `json:"skipDefaults,omitempty"`
+}
+
+// pathSegment 路径中的一段，wildcard 匹配所有元素
+type pathSegment struct {
+	key      string
+	wildcard bool
+}
+
+type fieldPath []pathSegment
+
+// parseFieldPath 解析字段路径，如 metadata.annotations["a.b/c"]、spec.containers[*].image
+func parseFieldPath(s string) (fieldPath, error) {
+	var path fieldPath
+	rest := s
+	for rest != "" {
+		var seg pathSegment
+		if strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: missing ]", s)
+			}
+			inner := rest[1:end]
+			switch {
+			case inner == "*":
+				seg.wildcard = true
+			case len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0]:
+				seg.key = inner[1 : len(inner)-1]
+			default:
+				return nil, fmt.Errorf("invalid path %q: expected [*] or quoted key", s)
+			}
+			rest = rest[end+1:]
+		} else {
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			seg.key = rest[:end]
+			seg.wildcard = seg.key == "*"
+			rest = rest[end:]
+		}
+		if seg.key == "" && !seg.wildcard {
+			return nil, fmt.Errorf("invalid path %q: empty segment", s)
+		}
+		path = append(path, seg)
+		if strings.HasPrefix(rest, ".") {
+			rest = rest[1:]
+			if rest == "" {
+				return nil, fmt.Errorf("invalid path %q: trailing .", s)
+			}
+		}
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("empty path")
+	}
+	return path, nil
+}
+
+// visit 对路径匹配的每个字段所在的父对象调用 fn，只遍历已存在的字段
+func (p fieldPath) visit(node interface{}, fn func(parent map[string]interface{}, key string)) {
+	seg, last := p[0], len(p) == 1
+	switch v := node.(type) {
+	case map[string]interface{}:
+		keys := []string{seg.key}
+		if seg.wildcard {
+			keys = make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+		}
+		for _, key := range keys {
+			child, ok := v[key]
+			if !ok {
+				continue
+			}
+			if last {
+				fn(v, key)
+			} else {
+				p[1:].visit(child, fn)
+			}
+		}
+	case []interface{}:
+		// 数组元素只能被遍历，不能被移除或改写
+		if !seg.wildcard || last {
+			return
+		}
+		for _, child := range v {
+			p[1:].visit(child, fn)
+		}
+	}
+}
+
+// objectTransform 编译后的裁剪规则
+type objectTransform struct {
+	drop []fieldPath
+	set  []fieldValue
+}
+
+type fieldValue struct {
+	path  fieldPath
+	value interface{}
+}
+
+// newObjectTransform 编译裁剪规则，cfg 为空时只移除默认字段，没有任何规则时返回 nil
+func newObjectTransform(cfg *TransformConfig) (*objectTransform, error) {
+	if cfg == nil {
+		cfg = &TransformConfig{}
+	}
+	var drops []string
+	if !cfg.SkipDefaults {
+		drops = append(drops, defaultDropPaths...)
+	}
+	drops = append(drops, cfg.Drop...)
+	if len(drops) == 0 && len(cfg.Set) == 0 {
+		return nil, nil
+	}
+
+	t := &objectTransform{}
+	for _, s := range drops {
+		path, err := parseFieldPath(s)
+		if err != nil {
+			return nil, fmt.Errorf("drop: %w", err)
+		}
+		t.drop = append(t.drop, path)
+	}
+	// 按路径排序，保证改写顺序稳定
+	keys := make([]string, 0, len(cfg.Set))
+	for s := range cfg.Set {
+		keys = append(keys, s)
+	}
+	sort.Strings(keys)
+	for _, s := range keys {
+		path, err := parseFieldPath(s)
+		if err != nil {
+			return nil, fmt.Errorf("set: %w", err)
+		}
+		t.set = append(t.set, fieldValue{path: path, value: cfg.Set[s]})
+	}
+	return t, nil
+}
+
+// Transform 实现 cache.TransformFunc，就地修改 informer 收到的对象
+func (t *objectTransform) Transform(obj interface{}) (interface{}, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return obj, nil
+	}
+	for _, path := range t.drop {
+		path.visit(u.Object, func(parent map[string]interface{}, key string) {
+			delete(parent, key)
+		})
+	}
+	for _, fv := range t.set {
+		fv.path.visit(u.Object, func(parent map[string]interface{}, key string) {
+			parent[key] = runtime.DeepCopyJSONValue(fv.value)
+		})
+	}
+	// 移除注解后不保留空的 annotations
+	if annotations, found, _ := unstructured.NestedFieldNoCopy(u.Object, "metadata", "annotations"); found {
+		if m, ok := annotations.(map[string]interface{}); ok && len(m) == 0 {
+			unstructured.RemoveNestedField(u.Object, "metadata", "annotations")
+		}
+	}
+	return u, nil
+}
+
+// equalIgnoringResourceVersion 比较两个对象，忽略 metadata.resourceVersion
+func equalIgnoringResourceVersion(a, b *unstructured.Unstructured) bool {
+	return reflect.DeepEqual(withoutResourceVersion(a.Object), withoutResourceVersion(b.Object))
+}
+
+// withoutResourceVersion 返回去掉 metadata.resourceVersion 的浅拷贝，不修改 obj
+func withoutResourceVersion(obj map[string]interface{}) map[string]interface{} {
+	metadata, ok := obj["metadata"].(map[string]interface{})
+	if !ok {
+		return obj
+	}
+	copied := make(map[string]interface{}, len(obj))
+	for k, v := range obj {
+		copied[k] = v
+	}
+	trimmed := make(map[string]interface{}, len(metadata))
+	for k, v := range metadata {
+		if k != "resourceVersion" {
+			trimmed[k] = v
+		}
+	}
+	copied["metadata"] = trimmed
+	return copied
+}
+
+// setInformerTransform 为尚未启动的 informer 设置裁剪规则，informer 已由其他控制器启动时保持原有规则
+func (cm *ControllerManager) setInformerTransform(informer cache.SharedIndexInformer, t *objectTransform) {
+	cm.informersMu.Lock()
+	defer cm.informersMu.Unlock()
+	if shared := cm.lookupInformer(informer); shared == nil || shared.started {
+		return
+	}
+	var fn cache.TransformFunc
+	if t != nil {
+		fn = t.Transform
+	}
+	if err := informer.SetTransform(fn); err != nil {
+		klog.Warningf("Set transform failed: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// newTestNode 构造带心跳时间和 managedFields 的 Node
+func newTestNode(resourceVersion, heartbeat, ready string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Node",
+		"metadata": map[string]interface{}{
+			"name":            "node-1",
+			"resourceVersion": resourceVersion,
+			"managedFields": []interface{}{
+				map[string]interface{}{"manager": "kubelet", "time": heartbeat},
+			},
+		},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Ready", "status": ready, "lastHeartbeatTime": heartbeat},
+			},
+		},
+	}}
+}
+
+func TestTransformSuppressesSpuriousUpdates(t *testing.T) {
+	transform, err := newObjectTransform(&TransformConfig{Drop: []string{"status.conditions[*].lastHeartbeatTime"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply := func(obj *unstructured.Unstructured) *unstructured.Unstructured {
+		out, err := transform.Transform(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out.(*unstructured.Unstructured)
+	}
+
+	old := apply(newTestNode("1", "2024-01-01T00:00:00Z", "True"))
+	if _, found, _ := unstructured.NestedFieldNoCopy(old.Object, "metadata", "managedFields"); found {
+		t.Fatal("managedFields not dropped by default")
+	}
+
+	tests := []struct {
+		name string
+		new  *unstructured.Unstructured
+		want bool
+	}{
+		{name: "resync", new: apply(newTestNode("1", "2024-01-01T00:00:00Z", "True")), want: false},
+		{name: "heartbeat only", new: apply(newTestNode("2", "2024-01-01T00:00:10Z", "True")), want: false},
+		{name: "status changed", new: apply(newTestNode("3", "2024-01-01T00:00:20Z", "False")), want: true},
+	}
+	for _, tt := range tests {
+		if got := DefaultNeedUpdate(old, tt.new); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+	if old.GetResourceVersion() != "1" {
+		t.Fatal("comparison modified the object")
+	}
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/client-go/util/homedir"
 )
 
 // HasCycle 深度优先循环检测函数
-func HasCycle(depMap map[schema.GroupVersionResource][]schema.GroupVersionResource) bool {
-	visited := make(map[schema.GroupVersionResource]bool)
-	recursionStack := make(map[schema.GroupVersionResource]bool)
+func HasCycle[K comparable](depMap map[K][]K) bool {
+	visited := make(map[K]bool)
+	recursionStack := make(map[K]bool)
 
-	var detectCycle func(node schema.GroupVersionResource) bool
-	detectCycle = func(node schema.GroupVersionResource) bool {
+	var detectCycle func(node K) bool
+	detectCycle = func(node K) bool {
 		if recursionStack[node] {
 			return true
 		}
@@ -50,3 +54,23 @@ func stringSliceContains(slice []string, target string) bool {
 	}
 	return false
 }
+
+// runningInCluster 判断是否运行在 Pod 中
+func runningInCluster() bool {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") == "" {
+		return false
+	}
+	_, err := os.Stat(serviceAccountTokenFile)
+	return err == nil
+}
+
+// expandHome 展开路径中的 ~
+func expandHome(path string) string {
+	if path == "~" {
+		return homedir.HomeDir()
+	}
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(homedir.HomeDir(), path[2:])
+	}
+	return path
+}
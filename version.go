@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// syncGeneration 写入代数，以启动时间为初值单调递增，重启后仍大于之前写入的值
+var syncGeneration = time.Now().UnixNano()
+
+// nextGeneration 返回新的写入代数
+func nextGeneration() int64 {
+	return atomic.AddInt64(&syncGeneration, 1)
+}
+
+// generationKey context 中保存写入代数的键
+type generationKey struct{}
+
+// withGeneration 返回携带写入代数的 context，代数应在从缓存读取对象时分配，晚读取的对象更新
+func withGeneration(ctx context.Context, generation int64) context.Context {
+	return context.WithValue(ctx, generationKey{}, generation)
+}
+
+// generationFromContext 返回 ctx 中的写入代数，没有时分配新的代数
+func generationFromContext(ctx context.Context) int64 {
+	if generation, ok := ctx.Value(generationKey{}).(int64); ok {
+		return generation
+	}
+	return nextGeneration()
+}
+
+// ensureGeneration ctx 中没有写入代数时分配一个，保证同一次写入的条件和写入的值一致
+func ensureGeneration(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(generationKey{}).(int64); ok {
+		return ctx
+	}
+	return withGeneration(ctx, nextGeneration())
+}
+
+// isNumericResourceVersion ResourceVersion 是否为可比较的数字，etcd 存储的资源均为数字，
+// 聚合 API 等其他实现可能不是
+func isNumericResourceVersion(resourceVersion string) bool {
+	_, err := strconv.ParseUint(resourceVersion, 10, 64)
+	return err == nil
+}
+
+// guardColumn 写入条件比较的列
+func guardColumn(resourceVersion string) string {
+	if isNumericResourceVersion(resourceVersion) {
+		return columnResourceVersion
+	}
+	return columnSyncGeneration
+}
+
+// writeGuard 写入条件：传入对象不比 table 中已有记录旧
+// ResourceVersion 为数字时按数字比较：位数多的更大，位数相同时按字符串比较，不依赖各数据库的类型转换；
+// 否则比较写入代数。incoming 返回传入对象对应列的值或表达式
+func writeGuard(table, resourceVersion string, incoming func(column string) any) clause.Expression {
+	column := guardColumn(resourceVersion)
+	in, existing := incoming(column), clause.Column{Table: table, Name: column}
+	if column == columnSyncGeneration {
+		return clause.Expr{SQL: "? >= ?", Vars: []any{in, existing}}
+	}
+	return clause.Expr{
+		SQL:  "(LENGTH(?) > LENGTH(?) OR (LENGTH(?) = LENGTH(?) AND ? >= ?))",
+		Vars: []any{in, existing, in, existing, in, existing},
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ResourceRule 白名单或排除规则，三种形式：
+//   - group/version/resource，core 组可省略 group，每段支持 path.Match 通配符，如 apps/*/*、*.example.com/*/*、*/*/events
+//   - group/resource，省略版本，如 apps/deployments，使用服务端的首选版本
+//   - 不含 / 的分类名，如 all，匹配服务端发现结果中资源的 categories，只能用于白名单
+//
+// 同一资源匹配多个版本时只同步一个版本，见 Discover
+type ResourceRule struct {
+	Group    string
+	Version  string
+	Resource string
+	Category string
+}
+
+// ParseResourceRule 解析规则
+func ParseResourceRule(s string) (ResourceRule, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ResourceRule{}, fmt.Errorf("empty rule")
+	}
+	if !strings.Contains(s, "/") {
+		if hasWildcard(s) {
+			return ResourceRule{}, fmt.Errorf("invalid rule %q: category must not contain wildcards", s)
+		}
+		return ResourceRule{Category: s}, nil
+	}
+	gvr, err := ParseGVR(s)
+	if err != nil {
+		return ResourceRule{}, err
+	}
+	rule := ResourceRule{Group: gvr.Group, Version: gvr.Version, Resource: gvr.Resource}
+	if gvr.Group == "" && !hasWildcard(gvr.Version) && !versionPattern.MatchString(gvr.Version) {
+		// group/resource
+		rule.Group, rule.Version = gvr.Version, ""
+	}
+	for _, pattern := range []string{rule.Group, rule.Version, rule.Resource} {
+		if _, err = path.Match(pattern, ""); err != nil {
+			return ResourceRule{}, fmt.Errorf("invalid rule %q: %w", s, err)
+		}
+	}
+	return rule, nil
+}
+
+// ParseGroupResource 解析资源选项和依赖的键，接受 group/version/resource 和 group/resource，忽略版本，
+// 首选版本变化后配置仍然生效；不支持通配符和分类
+func ParseGroupResource(s string) (schema.GroupResource, error) {
+	rule, err := ParseResourceRule(s)
+	if err != nil {
+		return schema.GroupResource{}, err
+	}
+	if rule.IsCategory() || hasWildcard(rule.Group+rule.Version+rule.Resource) {
+		return schema.GroupResource{}, fmt.Errorf("invalid resource %q: expected group/resource or group/version/resource", s)
+	}
+	return schema.GroupResource{Group: rule.Group, Resource: rule.Resource}, nil
+}
+
+// IsCategory 是否为分类规则
+func (r ResourceRule) IsCategory() bool {
+	return r.Category != ""
+}
+
+// IsExact 是否为不含通配符且指定了版本的 GVR
+func (r ResourceRule) IsExact() bool {
+	return !r.IsCategory() && r.Version != "" && !hasWildcard(r.Group+r.Version+r.Resource)
+}
+
+// GVR 返回规则对应的 GVR，仅对 IsExact 的规则有意义
+func (r ResourceRule) GVR() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: r.Group, Version: r.Version, Resource: r.Resource}
+}
+
+// Match 检查 GVR 是否匹配规则，categories 为服务端发现结果中资源的分类，未知时为空
+func (r ResourceRule) Match(gvr schema.GroupVersionResource, categories []string) bool {
+	if r.IsCategory() {
+		return stringSliceContains(categories, r.Category)
+	}
+	return match(r.Group, gvr.Group) && (r.Version == "" || match(r.Version, gvr.Version)) && match(r.Resource, gvr.Resource)
+}
+
+func (r ResourceRule) String() string {
+	if r.IsCategory() {
+		return r.Category
+	}
+	if r.Version == "" {
+		return r.Group + "/" + r.Resource
+	}
+	return FormatGVR(r.GVR())
+}
+
+// versionPattern Kubernetes API 版本格式，如 v1、v2beta1
+var versionPattern = regexp.MustCompile(`^v[0-9]+((alpha|beta)[0-9]+)?$`)
+
+// hasWildcard 是否包含 path.Match 的特殊字符
+func hasWildcard(s string) bool {
+	return strings.ContainsAny(s, `*?[\`)
+}
+
+// match 规则已在解析时校验，忽略 path.ErrBadPattern
+func match(pattern, name string) bool {
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// ResourceFilter 白名单与排除规则，排除优先
+type ResourceFilter struct {
+	Include []ResourceRule
+	Exclude []ResourceRule
+}
+
+// Match 检查资源是否需要同步
+func (f *ResourceFilter) Match(gvr schema.GroupVersionResource, categories []string) bool {
+	for _, rule := range f.Exclude {
+		if rule.Match(gvr, categories) {
+			return false
+		}
+	}
+	for _, rule := range f.Include {
+		if rule.Match(gvr, categories) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchExact 检查 GVR 是否由精确规则指定且未被排除
+func (f *ResourceFilter) MatchExact(gvr schema.GroupVersionResource) bool {
+	for _, rule := range f.Include {
+		if rule.IsExact() && rule.GVR() == gvr {
+			return f.Match(gvr, nil)
+		}
+	}
+	return false
+}
+
+// MayMatch 在不知道资源分类时判断资源是否可能需要同步，分类规则视为可能匹配
+func (f *ResourceFilter) MayMatch(gvr schema.GroupVersionResource) bool {
+	for _, rule := range f.Exclude {
+		if rule.Match(gvr, nil) {
+			return false
+		}
+	}
+	for _, rule := range f.Include {
+		if rule.IsCategory() || rule.Match(gvr, nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// MayMatchGroupResource 判断资源的任一版本是否可能需要同步，只有不限版本的排除规则生效
+func (f *ResourceFilter) MayMatchGroupResource(gr schema.GroupResource) bool {
+	matchGroupResource := func(rule ResourceRule) bool {
+		return match(rule.Group, gr.Group) && match(rule.Resource, gr.Resource)
+	}
+	for _, rule := range f.Exclude {
+		if (rule.Version == "" || rule.Version == "*") && matchGroupResource(rule) {
+			return false
+		}
+	}
+	for _, rule := range f.Include {
+		if rule.IsCategory() || matchGroupResource(rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExactGVRs 返回白名单中未被排除的精确 GVR，按名称排序
+func (f *ResourceFilter) ExactGVRs() []schema.GroupVersionResource {
+	var gvrs []schema.GroupVersionResource
+	seen := make(map[schema.GroupVersionResource]struct{})
+	for _, rule := range f.Include {
+		if _, ok := seen[rule.GVR()]; ok || !rule.IsExact() || !f.Match(rule.GVR(), nil) {
+			continue
+		}
+		seen[rule.GVR()] = struct{}{}
+		gvrs = append(gvrs, rule.GVR())
+	}
+	sort.Slice(gvrs, func(i, j int) bool {
+		return gvrs[i].String() < gvrs[j].String()
+	})
+	return gvrs
+}
+
+// HasPatterns 白名单是否包含通配符或分类规则
+func (f *ResourceFilter) HasPatterns() bool {
+	for _, rule := range f.Include {
+		if !rule.IsExact() {
+			return true
+		}
+	}
+	return false
+}
+
+// parseExcludeRules 解析排除规则，拒绝分类规则：排除时资源分类可能未知，
+// 且 secrets 这类不含 / 的写法容易被误认为资源名，作为分类解析后不会排除任何资源
+func parseExcludeRules(rules []string) ([]ResourceRule, error) {
+	parsed, err := parseResourceRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range parsed {
+		if rule.IsCategory() {
+			return nil, fmt.Errorf("invalid rule %q: exclude rules must name a resource, e.g. v1/%s or */*/%s", rule.Category, rule.Category, rule.Category)
+		}
+	}
+	return parsed, nil
+}
+
+// parseResourceRules 解析规则列表
+func parseResourceRules(rules []string) ([]ResourceRule, error) {
+	parsed := make([]ResourceRule, 0, len(rules))
+	for _, s := range rules {
+		rule, err := ParseResourceRule(s)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, rule)
+	}
+	return parsed, nil
+}
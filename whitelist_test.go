@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestParseResourceRule(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ResourceRule
+		exact   bool
+		wantErr bool
+	}{
+		{in: "v1/pods", want: ResourceRule{Version: "v1", Resource: "pods"}, exact: true},
+		{in: "apps/v1/deployments", want: ResourceRule{Group: "apps", Version: "v1", Resource: "deployments"}, exact: true},
+		{in: "apps/deployments", want: ResourceRule{Group: "apps", Resource: "deployments"}},
+		{in: " batch/v1/jobs ", want: ResourceRule{Group: "batch", Version: "v1", Resource: "jobs"}, exact: true},
+		{in: "apps/*/*", want: ResourceRule{Group: "apps", Version: "*", Resource: "*"}},
+		{in: "*/*/events", want: ResourceRule{Group: "*", Version: "*", Resource: "events"}},
+		{in: "*.example.com/*/*", want: ResourceRule{Group: "*.example.com", Version: "*", Resource: "*"}},
+		{in: "all", want: ResourceRule{Category: "all"}},
+		{in: "", wantErr: true},
+		{in: "al*", wantErr: true},
+		{in: "apps/v1/[deployments", wantErr: true},
+		{in: "a/b/c/d", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseResourceRule(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: unexpected error %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: expected %+v, got %+v", tt.in, tt.want, got)
+		}
+		if !tt.wantErr && got.IsExact() != tt.exact {
+			t.Errorf("%q: expected exact %v", tt.in, tt.exact)
+		}
+	}
+}
+
+func TestResourceFilterMatch(t *testing.T) {
+	cfg := &Config{
+		Whitelist: []string{"v1/pods", "v1/secrets", "v1/events", "apps/*/*", "*.example.com/*/*", "all"},
+		Exclude:   []string{"*/*/events", "apps/replicasets", "v1/secrets"},
+	}
+	filter, err := cfg.ResourceFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	widget := schema.GroupVersionResource{Group: "demo.example.com", Version: "v1", Resource: "widgets"}
+	tests := []struct {
+		name       string
+		gvr        schema.GroupVersionResource
+		categories []string
+		want       bool
+	}{
+		{name: "exact", gvr: CoreV1Pod, want: true},
+		{name: "excluded exact", gvr: CoreV1Secret, want: false},
+		{name: "excluded by wildcard", gvr: CoreV1Event, want: false},
+		{name: "excluded in other group", gvr: schema.GroupVersionResource{Group: "events.k8s.io", Version: "v1", Resource: "events"}, categories: []string{"all"}, want: false},
+		{name: "group wildcard", gvr: AppsV1Deployment, want: true},
+		{name: "excluded without version", gvr: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"}, want: false},
+		{name: "domain wildcard", gvr: widget, want: true},
+		{name: "category", gvr: BatchV1Job, categories: []string{"all"}, want: true},
+		{name: "not listed", gvr: BatchV1Job, want: false},
+	}
+	for _, tt := range tests {
+		if got := filter.Match(tt.gvr, tt.categories); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+	if !filter.MayMatch(BatchV1Job) || filter.MayMatch(CoreV1Secret) {
+		t.Error("unexpected MayMatch result")
+	}
+	if filter.MayMatchGroupResource(schema.GroupResource{Group: "apps", Resource: "replicasets"}) {
+		t.Error("excluded group resource may match")
+	}
+	if got := filter.ExactGVRs(); len(got) != 1 || got[0] != CoreV1Pod {
+		t.Errorf("unexpected exact GVRs %v", got)
+	}
+	if !filter.HasPatterns() {
+		t.Error("expected patterns")
+	}
+}
+
+func TestExcludeRejectsCategories(t *testing.T) {
+	for _, exclude := range []string{"secrets", "all"} {
+		cfg := &Config{Whitelist: []string{"v1/secrets"}, Exclude: []string{exclude}}
+		if _, err := cfg.ResourceFilter(); err == nil {
+			t.Errorf("%s: expected bare name in exclude to be rejected", exclude)
+		}
+	}
+}
+
+func TestTableNameByGroup(t *testing.T) {
+	tests := []struct {
+		gvr  schema.GroupVersionResource
+		want string
+	}{
+		{gvr: CoreV1Pod, want: "Pod"},
+		{gvr: AppsV1Deployment, want: "apps_Deployment"},
+		{gvr: schema.GroupVersionResource{Group: "networking.istio.io", Version: "v1", Resource: "gateways"}, want: "networking_istio_io_Gateway"},
+		{gvr: schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "gateways"}, want: "gateway_networking_k8s_io_Gateway"},
+	}
+	for _, tt := range tests {
+		d := NewDao("test", nil, tt.gvr, true, nil)
+		if got := d.TableName(context.Background()); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.gvr, tt.want, got)
+		}
+	}
+
+	// 过长的组名截断并加上哈希，不同组仍得到不同的表名
+	long := func(group string) string {
+		gvr := schema.GroupVersionResource{Group: group, Version: "v1", Resource: "policies"}
+		return NewDao("test", nil, gvr, true, nil).TableName(context.Background())
+	}
+	a, b := long("security.policies.a.very-long-vendor.example.com"), long("security.policies.b.very-long-vendor.example.com")
+	if len(a) > maxTableNameLength || len(b) > maxTableNameLength || a == b {
+		t.Errorf("unexpected long table names %s %s", a, b)
+	}
+}
+
+func TestMigrateLegacyTable(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	istio := schema.GroupVersionResource{Group: "networking.istio.io", Version: "v1", Resource: "gateways"}
+	gateway := schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "gateways"}
+	newGateway := func(gvr schema.GroupVersionResource, name string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(gvr.GroupVersion().String())
+		obj.SetKind("Gateway")
+		obj.SetNamespace("default")
+		obj.SetName(name)
+		obj.SetUID(types.UID("uid-" + name))
+		obj.SetResourceVersion("1")
+		return obj
+	}
+
+	// 旧版本中两个组的记录和历史版本写入同一张 Gateway 和 GatewayHistory 表
+	legacy := &DynamicModel{Resource: "gateways"}
+	legacyHistory := legacy.legacyTableName() + historyTableSuffix
+	if err := db.Table(legacy.legacyTableName()).AutoMigrate(legacy); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Table(legacyHistory).AutoMigrate(&Revision{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range []*unstructured.Unstructured{newGateway(istio, "istio"), newGateway(gateway, "gateway")} {
+		model := NewDao("test", db, istio, true, nil).GetModel(ctx, obj)
+		if err := db.Table(legacy.legacyTableName()).Create(model).Error; err != nil {
+			t.Fatal(err)
+		}
+		revision := &Revision{ClusterID: "test", UID: model.GetUID(), Name: model.GetName(), Action: ActionAdd, Raw: model.GetRaw()}
+		if err := db.Table(legacyHistory).Create(revision).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for gvr, name := range map[schema.GroupVersionResource]string{istio: "istio", gateway: "gateway"} {
+		d := NewHistoryDao("test", db, NewDao("test", db, gvr, true, nil))
+		if err := d.AutoMigrate(ctx); err != nil {
+			t.Fatalf("%s: %v", gvr, err)
+		}
+		rows, err := d.Find(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 1 || rows[0].GetName() != name {
+			t.Fatalf("%s: expected only %s to be moved, got %d rows", gvr, name, len(rows))
+		}
+		var revisions int64
+		if err = db.Table(d.TableName(ctx) + historyTableSuffix).Where(columnEq(columnUID, "uid-"+name)).Count(&revisions).Error; err != nil || revisions != 1 {
+			t.Fatalf("%s: expected the revision to be moved, got %d: %v", gvr, revisions, err)
+		}
+	}
+	for _, table := range []string{legacy.legacyTableName(), legacyHistory} {
+		var left int64
+		if err := db.Table(table).Count(&left).Error; err != nil || left != 0 {
+			t.Fatalf("expected %s to be emptied, %d rows left: %v", table, left, err)
+		}
+	}
+}